@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+)
+
+const (
+	APITokenPrefix  = "pst_"
+	EditTokenPrefix = "pet_"
+)
+
+const (
+	ScopePasteRead   = "paste:read"
+	ScopePasteWrite  = "paste:write"
+	ScopeShareManage = "share:manage"
+)
+
+var Scopes = []string{ScopePasteRead, ScopePasteWrite, ScopeShareManage}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// GenerateAPIToken returns a new personal access token. Only its hash is
+// ever stored.
+func GenerateAPIToken() (string, error) {
+	return generateToken(APITokenPrefix)
+}
+
+// GenerateEditToken returns a new token for editing and deleting an
+// anonymous paste. Only its hash is ever stored.
+func GenerateEditToken() (string, error) {
+	return generateToken(EditTokenPrefix)
+}
+
+func generateToken(prefix string) (string, error) {
+	random := make([]byte, 32)
+
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// HashToken uses a plain SHA-256 rather than bcrypt: tokens are long and
+// random, and are checked on every request.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
+}
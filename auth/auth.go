@@ -6,24 +6,33 @@ import (
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 type Jwt struct {
-	SecretKey         string
+	Keys              *KeySet
 	Issuer            string
 	ExpirationMinutes int64
 	ExpirationHours   int64
 }
 
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
 type JwtClaim struct {
 	Email string
+	Type  string `json:"typ"`
 	jwt.StandardClaims
 }
 
 func (j *Jwt) GenerateToken(email string) (signedToken string, err error) {
 	claims := &JwtClaim{
 		Email: email,
+		Type:  AccessToken,
 		StandardClaims: jwt.StandardClaims{
+			Id: uuid.NewString(),
 			ExpiresAt: time.Now().Local().Add(
 				time.Minute * time.Duration(j.ExpirationMinutes),
 			).Unix(),
@@ -31,9 +40,7 @@ func (j *Jwt) GenerateToken(email string) (signedToken string, err error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedToken, err = token.SignedString([]byte(j.SecretKey))
+	signedToken, err = j.Keys.Sign(claims)
 	if err != nil {
 		log.Println(err)
 
@@ -46,7 +53,9 @@ func (j *Jwt) GenerateToken(email string) (signedToken string, err error) {
 func (j *Jwt) RefreshToken(email string) (signedtoken string, err error) {
 	claims := &JwtClaim{
 		Email: email,
+		Type:  RefreshToken,
 		StandardClaims: jwt.StandardClaims{
+			Id: uuid.NewString(),
 			ExpiresAt: time.Now().Local().Add(
 				time.Hour * time.Duration(j.ExpirationHours),
 			).Unix(),
@@ -54,9 +63,7 @@ func (j *Jwt) RefreshToken(email string) (signedtoken string, err error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedtoken, err = token.SignedString([]byte(j.SecretKey))
+	signedtoken, err = j.Keys.Sign(claims)
 	if err != nil {
 		log.Println(err)
 
@@ -66,14 +73,12 @@ func (j *Jwt) RefreshToken(email string) (signedtoken string, err error) {
 	return signedtoken, nil
 }
 
-func (j *Jwt) ValidateToken(signedToken string) (claims *JwtClaim, err error) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&JwtClaim{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(j.SecretKey), nil
-		},
-	)
+// ValidateToken checks the signature and expiry of signedToken and that it is
+// of the expected type, so refresh tokens can't be used as access tokens.
+func (j *Jwt) ValidateToken(signedToken string, tokenType string) (
+	claims *JwtClaim, err error,
+) {
+	token, err := jwt.ParseWithClaims(signedToken, &JwtClaim{}, j.Keys.Lookup)
 	if err != nil {
 		log.Println(err)
 
@@ -97,5 +102,13 @@ func (j *Jwt) ValidateToken(signedToken string) (claims *JwtClaim, err error) {
 		return nil, err
 	}
 
+	if claims.Type != tokenType {
+		err = errors.New("JWT is not of type " + tokenType)
+
+		log.Println(err)
+
+		return nil, err
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Key is a JWT key identified by the kid header. Keys loaded only for
+// verification have no signing half.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signing   interface{}
+	verifying interface{}
+}
+
+// KeySet holds the key new tokens are signed with, along with every key
+// tokens are still accepted from, so keys can be rotated without
+// invalidating tokens that are already out there.
+type KeySet struct {
+	Signing *Key
+	keys    map[string]*Key
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// LoadKeySet builds the key set from the environment:
+//   - JWT_ALGORITHM is HS256 (default), RS256 or EdDSA
+//   - JWT_KEY_ID is the kid of the signing key (default "default")
+//   - JWT_SECRET or JWT_SECRET_FILE holds the HS256 secret
+//   - JWT_PRIVATE_KEY_FILE holds the PEM private key for RS256 and EdDSA
+//   - JWT_VERIFICATION_KEYS_DIR holds older keys still accepted, as
+//     <kid>.pem public or private keys and <kid>.secret HS256 secrets
+func LoadKeySet() (*KeySet, error) {
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS256.Alg()
+	}
+
+	kid := os.Getenv("JWT_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	signing, err := loadSigningKey(algorithm, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{
+		Signing: signing,
+		keys:    map[string]*Key{kid: signing},
+	}
+
+	dir := os.Getenv("JWT_VERIFICATION_KEYS_DIR")
+	if dir != "" {
+		err = set.loadVerificationKeys(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+func loadSigningKey(algorithm string, kid string) (*Key, error) {
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		secret, err := readSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Key{
+			ID:        kid,
+			Method:    jwt.SigningMethodHS256,
+			signing:   secret,
+			verifying: secret,
+		}, nil
+	case jwt.SigningMethodRS256.Alg(), SigningMethodEd25519.Alg():
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, errors.New(
+				"JWT_PRIVATE_KEY_FILE is required for " + algorithm,
+			)
+		}
+
+		key, err := readPEMKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.signing == nil || key.Method.Alg() != algorithm {
+			return nil, fmt.Errorf(
+				"%s does not hold a %s private key", path, algorithm,
+			)
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+}
+
+func readSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+
+	path := os.Getenv("JWT_SECRET_FILE")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		secret = strings.TrimSpace(string(data))
+	}
+
+	if secret != "" {
+		return []byte(secret), nil
+	}
+
+	log.Println(
+		"No JWT_SECRET configured, using a random secret; " +
+			"tokens will not survive a restart",
+	)
+
+	random := make([]byte, 32)
+
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+
+	return random, nil
+}
+
+func (s *KeySet) loadVerificationKeys(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		ext := filepath.Ext(name)
+		kid := strings.TrimSuffix(name, ext)
+
+		if _, exists := s.keys[kid]; exists {
+			continue
+		}
+
+		var key *Key
+
+		switch ext {
+		case ".pem":
+			key, err = readPEMKey(kid, path)
+			if err != nil {
+				return err
+			}
+		case ".secret":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			secret := []byte(strings.TrimSpace(string(data)))
+			key = &Key{
+				ID:        kid,
+				Method:    jwt.SigningMethodHS256,
+				verifying: secret,
+			}
+		default:
+			continue
+		}
+
+		// Older keys are only ever used to verify.
+		key.signing = nil
+		s.keys[kid] = key
+	}
+
+	return nil
+}
+
+func readPEMKey(kid string, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	var parsed interface{}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{
+			ID:        kid,
+			Method:    jwt.SigningMethodRS256,
+			signing:   k,
+			verifying: &k.PublicKey,
+		}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, verifying: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{
+			ID:        kid,
+			Method:    SigningMethodEd25519,
+			signing:   k,
+			verifying: k.Public(),
+		}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: SigningMethodEd25519, verifying: k}, nil
+	}
+
+	return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+}
+
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.Signing.Method, claims)
+	token.Header["kid"] = s.Signing.ID
+
+	return token.SignedString(s.Signing.signing)
+}
+
+// Lookup is a jwt.Keyfunc returning the verification key named by the
+// token's kid. The token's algorithm must match the key's, so an RSA public
+// key can never be used as an HMAC secret.
+func (s *KeySet) Lookup(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf(
+			"token algorithm %s does not match key %q", token.Method.Alg(), kid,
+		)
+	}
+
+	return key.verifying, nil
+}
+
+// JWKS publishes the public keys of the set. HMAC secrets are never
+// included.
+func (s *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for kid, key := range s.keys {
+		jwk := JSONWebKey{
+			Kid: kid,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+
+		switch k := key.verifying.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(k.E)).Bytes(),
+			)
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
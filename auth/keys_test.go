@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeySetHS256(t *testing.T) {
+	t.Setenv("JWT_ALGORITHM", "")
+	t.Setenv("JWT_KEY_ID", "current")
+	t.Setenv("JWT_SECRET", "current-secret")
+	t.Setenv("JWT_SECRET_FILE", "")
+
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "old.secret"), []byte("old-secret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_VERIFICATION_KEYS_DIR", dir)
+
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys.Signing.ID != "current" {
+		t.Errorf("signing key = %q, want %q", keys.Signing.ID, "current")
+	}
+
+	if keys.keys["old"] == nil || keys.keys["old"].signing != nil {
+		t.Error("the retired key should be loaded for verification only")
+	}
+
+	issuer := Jwt{Keys: keys, Issuer: "test", ExpirationMinutes: 5}
+
+	token, err := issuer.GenerateToken("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.ValidateToken(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Email != "someone@example.com" {
+		t.Errorf("email = %q, want someone@example.com", claims.Email)
+	}
+
+	_, err = issuer.ValidateToken(token, RefreshToken)
+	if err == nil {
+		t.Error("an access token was accepted as a refresh token")
+	}
+
+	// HMAC secrets are never published.
+	if jwks := keys.JWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("JWKS published %d keys, want none", len(jwks.Keys))
+	}
+}
+
+func TestLoadKeySetRejectsUnknownAlgorithm(t *testing.T) {
+	t.Setenv("JWT_ALGORITHM", "none")
+
+	_, err := LoadKeySet()
+	if err == nil {
+		t.Error("LoadKeySet accepted the none algorithm")
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestShareLinkSigner(t *testing.T) {
+	signer := NewShareLinkSigner([]byte("secret"))
+	id := uuid.New()
+
+	token := signer.Sign(id)
+	if !strings.HasPrefix(token, ShareLinkPrefix) {
+		t.Errorf("token %q is missing the %q prefix", token, ShareLinkPrefix)
+	}
+
+	got, err := signer.Verify(token)
+	if err != nil || got != id {
+		t.Errorf("Verify = %v, %v; want %v", got, err, id)
+	}
+
+	other := NewShareLinkSigner([]byte("another secret"))
+
+	_, err = other.Verify(token)
+	if err != ErrInvalidShareLink {
+		t.Errorf("Verify with another secret = %v, want ErrInvalidShareLink", err)
+	}
+
+	for _, forged := range []string{
+		"",
+		strings.TrimPrefix(token, ShareLinkPrefix),
+		token + "x",
+		ShareLinkPrefix + "not.base64!",
+	} {
+		_, err = signer.Verify(forged)
+		if err != ErrInvalidShareLink {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidShareLink", forged, err)
+		}
+	}
+}
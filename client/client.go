@@ -0,0 +1,248 @@
+// Package client talks to the pastey API. It encrypts pastes before they are
+// uploaded, so the server only ever stores ciphertext and the key travels in
+// the fragment of the paste's link, which browsers never send.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+var ErrInvalidLink = errors.New("not a paste link with a key")
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("pastey: %d %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+	BaseURL string
+	// Token is an access token or personal API token. It may be empty when
+	// the server allows anonymous pastes.
+	Token      string
+	HTTPClient *http.Client
+}
+
+func New(baseURL string, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+type CreateOptions struct {
+	Title      string
+	Visibility models.Visibility
+	// ExpiresIn is sent as Pastey-Expires-In, such as "24h".
+	ExpiresIn        string
+	BurnAfterReading bool
+	Language         string
+}
+
+type CreatedPaste struct {
+	Paste models.Paste
+	// EditToken is only set for anonymous pastes.
+	EditToken string
+	// Link holds the decryption key in its fragment.
+	Link string
+}
+
+// CreateEncrypted encrypts content with a new key and uploads it.
+func (c *Client) CreateEncrypted(
+	ctx context.Context, opts CreateOptions, content []byte,
+) (*CreatedPaste, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := Encrypt(key, content)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", "paste")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = part.Write(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	err = form.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/paste", &body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Pastey-Title", opts.Title)
+	req.Header.Set("Pastey-Visibility", opts.Visibility.String())
+	req.Header.Set("Pastey-Encryption", Algorithm)
+
+	if opts.ExpiresIn != "" {
+		req.Header.Set("Pastey-Expires-In", opts.ExpiresIn)
+	}
+
+	if opts.BurnAfterReading {
+		req.Header.Set("Pastey-Burn-After-Reading", "true")
+	}
+
+	if opts.Language != "" {
+		req.Header.Set("Pastey-Language", opts.Language)
+	}
+
+	var created struct {
+		Data      models.Paste `json:"data"`
+		EditToken string       `json:"edit_token"`
+	}
+
+	err = c.do(req, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreatedPaste{
+		Paste:     created.Data,
+		EditToken: created.EditToken,
+		Link:      c.Link(created.Data.ID, key),
+	}, nil
+}
+
+// GetEncrypted downloads the paste a link points to and decrypts it with the
+// key in the link's fragment.
+func (c *Client) GetEncrypted(ctx context.Context, link string) ([]byte, error) {
+	pasteId, key, err := ParseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(
+		ctx, http.MethodGet, "/api/v1/paste/"+url.PathEscape(pasteId)+"/file", nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError(res)
+	}
+
+	ciphertext, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decrypt(key, ciphertext)
+}
+
+func (c *Client) Link(pasteId string, key []byte) string {
+	return c.BaseURL + "/api/v1/paste/" + url.PathEscape(pasteId) + "/file#" +
+		base64.RawURLEncoding.EncodeToString(key)
+}
+
+// ParseLink extracts the paste ID and key from a link made by Link.
+func ParseLink(link string) (string, []byte, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", nil, err
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var pasteId string
+
+	for i, segment := range segments {
+		if segment == "paste" && i+1 < len(segments) {
+			pasteId = segments[i+1]
+		}
+	}
+
+	if pasteId == "" || u.Fragment == "" {
+		return "", nil, ErrInvalidLink
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(u.Fragment)
+	if err != nil || len(key) != keySize {
+		return "", nil, ErrInvalidLink
+	}
+
+	return pasteId, key, nil
+}
+
+func (c *Client) newRequest(
+	ctx context.Context, method string, path string, body io.Reader,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request, v interface{}) error {
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return responseError(res)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func responseError(res *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+
+	err := json.NewDecoder(res.Body).Decode(&body)
+	if err != nil || body.Message == "" {
+		body.Message = http.StatusText(res.StatusCode)
+	}
+
+	return &Error{StatusCode: res.StatusCode, Message: body.Message}
+}
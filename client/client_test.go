@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func TestLinkRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New("https://paste.example.com/", "")
+
+	link := c.Link("abc-123", key)
+	if !strings.HasPrefix(link, "https://paste.example.com/api/v1/paste/abc-123/file#") {
+		t.Errorf("Link = %q", link)
+	}
+
+	pasteId, parsed, err := ParseLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pasteId != "abc-123" || !bytes.Equal(parsed, key) {
+		t.Errorf("ParseLink(%q) = %q, %x, want abc-123, %x", link, pasteId, parsed, key)
+	}
+}
+
+func TestParseLinkRejectsMalformedLinks(t *testing.T) {
+	key, _ := GenerateKey()
+	link := New("https://paste.example.com", "").Link("abc", key)
+	path, fragment, _ := strings.Cut(link, "#")
+
+	for _, bad := range []string{
+		path,
+		path + "#",
+		path + "#not base64!",
+		path + "#" + fragment[:10],
+		path + "#" + fragment + "AAAA",
+		"https://paste.example.com/api/v1/paste/#" + fragment,
+		"https://paste.example.com/somewhere/else#" + fragment,
+		"://not a url#" + fragment,
+	} {
+		_, _, err := ParseLink(bad)
+		if err == nil {
+			t.Errorf("ParseLink(%q) accepted it", bad)
+		}
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	var stored []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/paste":
+			if r.Header.Get("Pastey-Encryption") != Algorithm {
+				t.Errorf("Pastey-Encryption = %q", r.Header.Get("Pastey-Encryption"))
+			}
+
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			stored, _ = io.ReadAll(file)
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": models.Paste{ID: "abc"},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/paste/abc/file":
+			w.Write(stored)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Paste not found"})
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "")
+
+	created, err := c.CreateEncrypted(context.Background(), CreateOptions{
+		Title: "secret", Visibility: models.Unlisted,
+	}, []byte("the plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stored, []byte("the plaintext")) {
+		t.Error("the server was sent the plaintext")
+	}
+
+	content, err := c.GetEncrypted(context.Background(), created.Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "the plaintext" {
+		t.Errorf("GetEncrypted = %q, want %q", content, "the plaintext")
+	}
+
+	// A link with another key fails to decrypt rather than returning junk.
+	other, _ := GenerateKey()
+
+	_, err = c.GetEncrypted(context.Background(), c.Link("abc", other))
+	if err == nil {
+		t.Error("GetEncrypted decrypted with the wrong key")
+	}
+
+	_, err = c.GetEncrypted(context.Background(), c.Link("missing", other))
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound ||
+		apiErr.Message != "Paste not found" {
+		t.Errorf("GetEncrypted of a missing paste = %v, want a 404 Error", err)
+	}
+}
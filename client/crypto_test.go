@@ -0,0 +1,75 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, plaintext := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("paste "), 10000)} {
+		ciphertext, err := Encrypt(key, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(plaintext) > 0 && bytes.Contains(ciphertext, plaintext) {
+			t.Errorf("%d bytes were encrypted into their plaintext", len(plaintext))
+		}
+
+		decrypted, err := Decrypt(key, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%d bytes decrypted to %d different bytes", len(plaintext), len(decrypted))
+		}
+	}
+
+	// Every encryption gets its own nonce.
+	first, _ := Encrypt(key, []byte("same"))
+	second, _ := Encrypt(key, []byte("same"))
+
+	if bytes.Equal(first, second) {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptRejectsWrongKeysAndTampering(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+
+	ciphertext, err := Encrypt(key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Decrypt(other, ciphertext)
+	if err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 1
+
+	_, err = Decrypt(key, tampered)
+	if err == nil {
+		t.Error("decrypted a tampered ciphertext")
+	}
+
+	_, err = Decrypt(key, ciphertext[:5])
+	if !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("decrypting a truncated ciphertext = %v, want ErrInvalidCiphertext", err)
+	}
+
+	_, err = Encrypt([]byte("short"), []byte("secret"))
+	if err == nil {
+		t.Error("encrypted with a 5 byte key")
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// pasteRole returns the most privileged role a user has on a paste, or "" if
+// they have none. Memberships are checked on every request, so removing
+// someone from a team or organization takes effect immediately.
+//
+// Pastes owned by an organization are owned by its admins, and by their
+// creator for as long as they stay in the organization.
+func (h *Handler) pasteRole(userId uuid.UUID, paste *models.Paste) (string, error) {
+	role := ""
+
+	if paste.OrganizationID == nil {
+		if !paste.Anonymous() && paste.UserID == userId {
+			return models.RoleOwner, nil
+		}
+	} else {
+		membership, err := h.Access.GetOrganizationMembership(
+			*paste.OrganizationID, userId,
+		)
+		if err == nil {
+			if membership.Role == models.OrganizationAdmin ||
+				paste.UserID == userId {
+				return models.RoleOwner, nil
+			}
+
+			if paste.Visibility == models.OrgOnly {
+				role = models.RoleViewer
+			}
+		} else if err != gorm.ErrRecordNotFound {
+			return "", err
+		}
+	}
+
+	access, err := h.Access.GetPasteAccessRecordByUserIdAndPasteId(
+		userId, paste.ID,
+	)
+	if err == nil {
+		// The creator's own grant only lists the paste for them.
+		if access.AccessRole() != models.RoleOwner {
+			role = models.HigherRole(role, access.AccessRole())
+		}
+	} else if err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+
+	teamRoles, err := h.Access.GetTeamPasteRoles(userId, paste.ID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, teamRole := range teamRoles {
+		role = models.HigherRole(role, teamRole)
+	}
+
+	return role, nil
+}
+
+// hasPasteRole reports whether a user has at least the given role on a
+// paste.
+func (h *Handler) hasPasteRole(userId uuid.UUID, paste *models.Paste, minimum string) (
+	bool, error,
+) {
+	role, err := h.pasteRole(userId, paste)
+	if err != nil {
+		return false, err
+	}
+
+	return models.RoleAtLeast(role, minimum), nil
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const editTokenHeader = "Pastey-Edit-Token"
+
+// Anonymous pastes must be readable without logging in: there is no account
+// to share them with.
+func anonymousVisibilityAllowed(visibility models.Visibility) bool {
+	return visibility.Open()
+}
+
+// authorizePasteEdit checks that the caller has at least the minimum role on
+// paste, through their account or a share link, or for anonymous pastes the
+// edit token returned when it was created. It returns the user ID new
+// revisions are recorded under and the caller's role. If the caller isn't
+// allowed, it writes the error response and returns false.
+func (h *Handler) authorizePasteEdit(
+	c *gin.Context, paste *models.Paste, action string, minimum string,
+) (uuid.UUID, string, bool) {
+	linkRole := shareLinkRole(c)
+	if linkRole != "" {
+		if !models.RoleAtLeast(linkRole, minimum) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "This share link can't " + action + " this paste",
+			})
+
+			return uuid.Nil, "", false
+		}
+
+		return uuid.Nil, linkRole, true
+	}
+
+	if paste.Anonymous() {
+		editToken := c.Request.Header.Get(editTokenHeader)
+		if editToken != "" && paste.EditTokenHash != "" &&
+			subtle.ConstantTimeCompare(
+				[]byte(auth.HashToken(editToken)), []byte(paste.EditTokenHash),
+			) == 1 {
+			return uuid.Nil, models.RoleOwner, true
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Please provide the edit token of this paste",
+		})
+
+		return uuid.Nil, "", false
+	}
+
+	email, found := c.Get("email")
+	if !found {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "You are not authorized to " + action + " this paste",
+		})
+
+		return uuid.Nil, "", false
+	}
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return uuid.Nil, "", false
+	}
+
+	role, err := h.pasteRole(user.ID, paste)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return uuid.Nil, "", false
+	}
+
+	if !models.RoleAtLeast(role, minimum) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "You are not authorized to " + action + " this paste",
+		})
+
+		return uuid.Nil, "", false
+	}
+
+	return user.ID, role, true
+}
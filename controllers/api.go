@@ -1,35 +1,55 @@
 package controllers
 
 import (
+	"bufio"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
-	"github.com/XanderWatson/tasty-pastey/database"
-	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/internal/highlight"
 	"github.com/XanderWatson/tasty-pastey/internal/keygen"
 	"github.com/XanderWatson/tasty-pastey/models"
 )
 
-func CreatePasteController(c *gin.Context) {
+// PasteMetadataPayload holds the fields a metadata update can change. It is
+// bound instead of a whole paste so that editors can't set its owner,
+// organization or anything else that isn't theirs to change.
+type PasteMetadataPayload struct {
+	Title      string             `json:"title" form:"title"`
+	Language   string             `json:"language" form:"language"`
+	ExpiresAt  *time.Time         `json:"expires_at" form:"expires_at"`
+	Visibility *models.Visibility `json:"visibility" form:"visibility"`
+}
+
+func (h *Handler) CreatePasteController(c *gin.Context) {
 	log.Println("Inside CreatePasteController")
 
-	email, _ := c.Get("email")
+	// Without an email the request was let through anonymously.
+	var userId uuid.UUID
 
-	user, err := database.GetUserByEmail(email.(string))
-	if err != nil {
-		log.Println(err)
+	email, authenticated := c.Get("email")
+	if authenticated {
+		user, err := h.Users.GetUserByEmail(email.(string))
+		if err != nil {
+			log.Println(err)
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching user",
-		})
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error fetching user",
+			})
 
-		return
+			return
+		}
+
+		userId = user.ID
 	}
 
 	title := c.Request.Header.Get("Pastey-Title")
@@ -50,7 +70,7 @@ func CreatePasteController(c *gin.Context) {
 		return
 	}
 
-	visibility, err := strconv.Atoi(visibilityString)
+	visibility, err := models.ParseVisibility(visibilityString)
 	if err != nil {
 		log.Println(err)
 
@@ -61,33 +81,63 @@ func CreatePasteController(c *gin.Context) {
 		return
 	}
 
-	file, err := c.FormFile("file")
-	if err != nil {
-		log.Println(err)
-
+	if !authenticated && !anonymousVisibilityAllowed(visibility) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Please provide a file",
+			"message": "Anonymous pastes must be public or unlisted",
 		})
 
 		return
 	}
 
-	f, err := file.Open()
+	var organizationId *uuid.UUID
+
+	organization := c.Request.Header.Get("Pastey-Organization")
+	if organization != "" {
+		id, err := uuid.Parse(organization)
+		if err != nil || !authenticated {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid organization",
+			})
+
+			return
+		}
+
+		_, err = h.Access.GetOrganizationMembership(id, userId)
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "You are not a member of this organization",
+			})
+
+			return
+		} else if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error fetching organization",
+			})
+
+			return
+		}
+
+		organizationId = &id
+	}
+
+	file, form, err := openFilePart(c)
 	if err != nil {
 		log.Println(err)
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error opening file",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide a file",
 		})
 
 		return
 	}
 
-	defer f.Close()
+	defer file.Close()
 
 	var paste models.Paste
 
-	err = c.Bind(&paste)
+	err = binding.MapFormWithTag(&paste, form, "form")
 	if err != nil {
 		log.Println(err)
 
@@ -98,45 +148,178 @@ func CreatePasteController(c *gin.Context) {
 		return
 	}
 
-	paste.UserID = user.ID
+	algorithm, err := parseEncryption(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unknown encryption algorithm",
+		})
+
+		return
+	}
+
+	reader := bufio.NewReaderSize(file, sniffLen)
+	head, _ := reader.Peek(sniffLen)
+	filename := file.FileName()
+
+	// Nothing is detected from encrypted uploads; the client may still say
+	// what the language is.
+	if algorithm != "" {
+		head = nil
+		filename = ""
+	}
+
+	language := c.Request.Header.Get("Pastey-Language")
+	if language == "" {
+		language = paste.Language
+	}
+
+	paste.Language, err = resolveLanguage(language, filename, head)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unknown language",
+		})
+
+		return
+	}
+
+	if paste.Language == "" {
+		paste.Language = highlight.PlainText
+	}
+
+	paste.UserID = userId
 	paste.ID = keygen.GenerateKey()
 	paste.Title = title
 	paste.Visibility = visibility
+	paste.OrganizationID = organizationId
+	paste.Encrypted = algorithm != ""
+	paste.EncryptionAlgorithm = algorithm
+
+	burnString := c.Request.Header.Get("Pastey-Burn-After-Reading")
+	if burnString != "" {
+		burn, err := strconv.ParseBool(burnString)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid burn after reading value",
+			})
+
+			return
+		}
+
+		paste.BurnAfterReading = burn
+	}
+
+	expiresAt, set, err := parseExpiresIn(c)
+	if set {
+		paste.ExpiresAt = expiresAt
+	}
+
+	if err == nil {
+		err = validateExpiresAt(paste.ExpiresAt)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid expiry value",
+		})
+
+		return
+	}
 
-	err = fileupload.UploadFile(paste.ID, &f)
+	passwordHash, _, err := parseSetPassword(c)
 	if err != nil {
 		log.Println(err)
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error uploading file",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid password",
 		})
 
 		return
 	}
 
-	err = database.CreatePasteRecord(&paste)
+	paste.PasswordHash = passwordHash
+
+	revision := models.PasteRevision{
+		ID:       uuid.New(),
+		PasteID:  paste.ID,
+		UserID:   userId,
+		Language: paste.Language,
+	}
+	revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+
+	var editToken string
+
+	if !authenticated {
+		editToken, err = auth.GenerateEditToken()
+		if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error generating edit token",
+			})
+
+			return
+		}
+
+		paste.EditTokenHash = auth.HashToken(editToken)
+	}
+
+	capture := &searchCapture{}
+
+	fileInfo, err := h.uploadBlob(
+		revision.BlobKey, io.TeeReader(reader, capture),
+	)
 	if err != nil {
 		log.Println(err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error creating paste",
+			"message": "Error uploading file",
 		})
 
 		return
 	}
 
-	var pasteAccess models.PasteAccess
+	revision.Size = fileInfo.Size
+	revision.ContentHash = fileInfo.SHA256
 
-	pasteAccess.ID = uuid.New()
-	pasteAccess.PasteID = paste.ID
-	pasteAccess.UserID = user.ID
+	paste.BlobKey = revision.BlobKey
+	paste.Size = revision.Size
+	paste.ContentHash = revision.ContentHash
+
+	// Anonymous pastes have no owner to grant access to.
+	var owner *models.PasteAccess
+
+	if authenticated {
+		owner = &models.PasteAccess{
+			ID:      uuid.New(),
+			PasteID: paste.ID,
+			UserID:  userId,
+			Role:    models.RoleOwner,
+		}
+	}
 
-	err = database.CreatePasteAccessRecord(&pasteAccess)
+	err = h.Pastes.CreatePaste(&paste, &revision, owner)
 	if err != nil {
 		log.Println(err)
 
+		h.deleteBlobs(revision.BlobKey)
+
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error creating paste access",
+			"message": "Error creating paste",
+		})
+
+		return
+	}
+
+	paste.Revision = revision.Number
+
+	h.indexPaste(&paste, capture)
+
+	if !authenticated {
+		// The edit token is only ever shown here.
+		c.JSON(http.StatusCreated, gin.H{
+			"message":    "Paste created successfully!",
+			"data":       paste,
+			"edit_token": editToken,
 		})
 
 		return
@@ -148,12 +331,12 @@ func CreatePasteController(c *gin.Context) {
 	})
 }
 
-func GetPastesController(c *gin.Context) {
+func (h *Handler) GetPastesController(c *gin.Context) {
 	log.Println("Inside GetPastesController")
 
 	email, _ := c.Get("email")
 
-	user, err := database.GetUserByEmail(email.(string))
+	user, err := h.Users.GetUserByEmail(email.(string))
 	if err != nil {
 		log.Println(err)
 
@@ -164,106 +347,124 @@ func GetPastesController(c *gin.Context) {
 		return
 	}
 
-	userId := user.ID
+	opts := parsePasteListOptions(c, user.ID)
+	if opts == nil {
+		return
+	}
 
-	var pastes []models.Paste
+	// One extra paste tells whether there is another page.
+	limit := opts.Limit
+	opts.Limit++
 
-	pasteAccesses, err := database.GetPasteAccessRecordsByUserId(userId)
-	if err == gorm.ErrRecordNotFound {
-		c.JSON(http.StatusNotFound, gin.H{
-			"message": "No pastes found for this user",
-		})
-
-		return
-	} else if err != nil {
+	pastes, err := h.Pastes.ListPastes(*opts)
+	if err != nil {
 		log.Println(err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching paste accesses",
+			"message": "Error fetching pastes",
 		})
 
 		return
 	}
 
-	for _, pasteAccess := range pasteAccesses {
-		paste, err := database.GetPasteByID(pasteAccess.PasteID)
-		if err == nil {
-			pastes = append(pastes, *paste)
-		}
+	nextCursor := ""
+	if len(pastes) > limit {
+		pastes = pastes[:limit]
+		nextCursor = encodePasteCursor(
+			&pastes[limit-1], opts.Sort, opts.Descending,
+		)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Pastes for user with ID: " + userId.String(),
-		"data":    pastes,
+		"message":     "Pastes for user with ID: " + user.ID.String(),
+		"data":        pastes,
+		"next_cursor": nextCursor,
 	})
 }
 
-func GetPasteController(c *gin.Context) {
+func (h *Handler) GetPasteController(c *gin.Context) {
 	log.Println("Inside GetPasteController")
 
-	pasteId, found := c.Params.Get("id")
-	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Please provide the ID of the paste",
-		})
+	paste := h.getReadablePaste(c)
+	if paste == nil {
+		return
 	}
 
-	paste, err := database.GetPasteByID(pasteId)
-	if err != nil {
-		log.Println(err)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Paste with ID: " + paste.ID,
+		"data":    paste,
+	})
+}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching paste",
-		})
+func (h *Handler) GetPasteFileController(c *gin.Context) {
+	log.Println("Inside GetPasteFileController")
 
+	paste := h.getReadablePaste(c)
+	if paste == nil {
 		return
 	}
 
-	if paste.Visibility == 1 {
-		email, _ := c.Get("email")
-
-		user, err := database.GetUserByEmail(email.(string))
-		if err != nil {
-			log.Println(err)
+	if handleConditional(c, paste) {
+		return
+	}
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error fetching user",
-			})
+	// Pastes uploaded before sizes were recorded are always served whole.
+	if !paste.BurnAfterReading && paste.ContentHash != "" {
+		c.Header("Accept-Ranges", "bytes")
 
-			return
-		}
+		rangeHeader := c.Request.Header.Get("Range")
+		if rangeHeader != "" && rangeApplies(c, paste) {
+			r, ok, err := parseRange(rangeHeader, paste.Size)
+			if err != nil {
+				c.Header(
+					"Content-Range",
+					"bytes */"+strconv.FormatInt(paste.Size, 10),
+				)
+				c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+					"message": "Requested range not satisfiable",
+				})
 
-		userId := user.ID
+				return
+			}
 
-		_, err = database.GetPasteAccessRecordByUserIdAndPasteId(
-			userId, pasteId,
-		)
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "You are not authorized to view this paste",
-			})
+			if ok {
+				h.servePasteRange(c, paste, r)
 
-			return
+				return
+			}
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Paste with ID: " + pasteId,
-		"data":    paste,
-	})
+	file, filesize := h.openPasteFile(c, paste)
+	if file == nil {
+		return
+	}
+
+	file = h.claimPasteFile(c, paste, file)
+	if file == nil {
+		return
+	}
+
+	defer file.Close()
+
+	c.DataFromReader(
+		http.StatusOK, filesize, "application/octet-stream", file, nil,
+	)
 }
 
-func GetPasteFileController(c *gin.Context) {
-	log.Println("Inside GetPasteFileController")
+func (h *Handler) UpdatePasteController(c *gin.Context) {
+	log.Println("Inside UpdatePasteController")
 
 	pasteId, found := c.Params.Get("id")
 	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "Please provide the ID of the paste",
 		})
+
+		return
 	}
 
-	paste, err := database.GetPasteByID(pasteId)
+	paste, err := h.Pastes.GetPasteByID(pasteId)
 	if err != nil {
 		log.Println(err)
 
@@ -274,166 +475,244 @@ func GetPasteFileController(c *gin.Context) {
 		return
 	}
 
-	if paste.Visibility == 1 {
-		email, _ := c.Get("email")
-
-		user, err := database.GetUserByEmail(email.(string))
-		if err != nil {
-			log.Println(err)
-
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error fetching user",
-			})
+	// Until the reaper gets to them, expired pastes are only hidden, so they
+	// must not be revived with new content or a later expiry.
+	if paste.Expired() {
+		c.JSON(http.StatusGone, gin.H{
+			"message": "This paste has expired",
+		})
 
-			return
-		}
+		return
+	}
 
-		userId := user.ID
+	userId, role, ok := h.authorizePasteEdit(
+		c, paste, "update", models.RoleEditor,
+	)
+	if !ok {
+		return
+	}
 
-		_, err = database.GetPasteAccessRecordByUserIdAndPasteId(
-			userId, pasteId,
-		)
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "You are not authorized to view this paste",
-			})
+	expiresAt, expirySet, err := parseExpiresIn(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid expiry value",
+		})
 
-			return
-		}
+		return
 	}
 
-	file, filesize, err := fileupload.GetFile(pasteId)
+	passwordHash, passwordSet, err := parseSetPassword(c)
 	if err != nil {
 		log.Println(err)
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching file",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid password",
 		})
 
 		return
 	}
 
-	var fileByteArray = make([]byte, filesize)
+	if passwordSet && rejectAccessChange(c, role) {
+		return
+	}
 
-	_, err = file.Read(fileByteArray)
-	if err != nil {
-		if err != io.EOF {
+	metadataOnly, found := c.GetQuery("metadata")
+	if !found || metadataOnly == "false" {
+		file, _, err := openFilePart(c)
+		if err != nil {
 			log.Println(err)
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error reading file",
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Please provide a file",
 			})
 
 			return
 		}
-	}
 
-	c.Data(http.StatusOK, "application/octet-stream", fileByteArray)
-}
+		defer file.Close()
 
-func UpdatePasteController(c *gin.Context) {
-	log.Println("Inside UpdatePasteController")
+		algorithm, err := parseEncryption(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Unknown encryption algorithm",
+			})
 
-	email, _ := c.Get("email")
+			return
+		}
 
-	user, err := database.GetUserByEmail(email.(string))
-	if err != nil {
-		log.Println(err)
+		// Every revision of an encrypted paste is encrypted the same way, so
+		// any of them can be restored and decrypted with the same client.
+		if algorithm != paste.EncryptionAlgorithm {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Encryption of a paste can't be changed",
+			})
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching user",
-		})
+			return
+		}
 
-		return
-	}
+		reader := bufio.NewReaderSize(file, sniffLen)
+		head, _ := reader.Peek(sniffLen)
+		filename := file.FileName()
 
-	userId := user.ID
+		if paste.Encrypted {
+			head = nil
+			filename = ""
+		}
 
-	pasteId, found := c.Params.Get("id")
-	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Please provide the ID of the paste",
-		})
-	}
+		language, err := resolveLanguage(
+			c.Request.Header.Get("Pastey-Language"), filename, head,
+		)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Unknown language",
+			})
 
-	paste, err := database.GetPasteByID(pasteId)
-	if err != nil {
-		log.Println(err)
+			return
+		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching paste",
-		})
+		if language == "" {
+			language = paste.Language
+		}
 
-		return
-	}
+		// Only the title and visibility are written here: the content fields
+		// are set by committing the revision, and writing them again could
+		// undo a newer revision committed in the meantime.
+		var update models.Paste
+		var visibility *models.Visibility
 
-	if paste.UserID != userId {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "You are not authorized to update this paste",
-		})
+		update.Title = c.Request.Header.Get("Pastey-Title")
+		if update.Title != "" {
+			paste.Title = update.Title
+		}
 
-		return
-	}
+		visibilityString := c.Request.Header.Get("Pastey-Visibility")
+		if visibilityString != "" {
+			if rejectAccessChange(c, role) {
+				return
+			}
 
-	metadataOnly, found := c.GetQuery("metadata")
-	if !found || metadataOnly == "false" {
-		file, err := c.FormFile("file")
+			parsed, err := models.ParseVisibility(visibilityString)
+			if err != nil {
+				log.Println(err)
+
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "Invalid visibility value",
+				})
+
+				return
+			}
+
+			if paste.Anonymous() && !anonymousVisibilityAllowed(parsed) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "Anonymous pastes must be public or unlisted",
+				})
+
+				return
+			}
+
+			visibility = &parsed
+		}
+
+		_, err = h.uploadPasteRevision(paste, userId, language, reader)
 		if err != nil {
 			log.Println(err)
 
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Please provide a file",
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error uploading file",
 			})
 
 			return
 		}
 
-		f, err := file.Open()
+		err = h.Pastes.UpdatePasteRecord(pasteId, &update)
 		if err != nil {
 			log.Println(err)
 
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error opening file",
+				"message": "Error updating paste",
 			})
 
 			return
 		}
 
-		defer f.Close()
+		// Public is the zero visibility, which Updates would skip.
+		if visibility != nil {
+			err = h.Pastes.UpdatePasteVisibility(pasteId, *visibility)
+			if err != nil {
+				log.Println(err)
 
-		err = fileupload.UploadFile(pasteId, &f)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Error updating paste",
+				})
+
+				return
+			}
+		}
+	} else {
+		var payload PasteMetadataPayload
+
+		err = c.Bind(&payload)
 		if err != nil {
 			log.Println(err)
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error uploading file",
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Please provide valid data",
 			})
 
 			return
 		}
 
-		title := c.Request.Header.Get("Pastey-Title")
-		if title != "" {
-			paste.Title = title
+		if payload.Visibility != nil {
+			if !payload.Visibility.Valid() {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "Invalid visibility value",
+				})
+
+				return
+			}
+
+			if rejectAccessChange(c, role) {
+				return
+			}
+
+			if paste.Anonymous() &&
+				!anonymousVisibilityAllowed(*payload.Visibility) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "Anonymous pastes must be public or unlisted",
+				})
+
+				return
+			}
 		}
 
-		visibilityString := c.Request.Header.Get("Pastey-Visibility")
-		if visibilityString != "" {
-			visibility, err := strconv.Atoi(visibilityString)
-			if err != nil {
-				log.Println(err)
+		update := models.Paste{
+			Title:     payload.Title,
+			ExpiresAt: payload.ExpiresAt,
+		}
 
+		if payload.Language != "" {
+			language, ok := highlight.Normalize(payload.Language)
+			if !ok {
 				c.JSON(http.StatusBadRequest, gin.H{
-					"message": "Invalid visibility value",
+					"message": "Unknown language",
 				})
 
 				return
 			}
 
-			paste.Visibility = visibility
+			update.Language = language
+		}
+
+		err = validateExpiresAt(update.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid expiry value",
+			})
+
+			return
 		}
 
-		err = database.UpdatePasteRecord(pasteId, paste)
+		err = h.Pastes.UpdatePasteRecord(pasteId, &update)
 		if err != nil {
 			log.Println(err)
 
@@ -443,21 +722,45 @@ func UpdatePasteController(c *gin.Context) {
 
 			return
 		}
-	} else {
-		var paste models.Paste
 
-		err = c.Bind(&paste)
+		if update.Title != "" {
+			err = h.Pastes.UpdatePasteSearchTitle(pasteId, update.Title)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		// Public is the zero visibility, which Updates would skip.
+		if payload.Visibility != nil {
+			err = h.Pastes.UpdatePasteVisibility(pasteId, *payload.Visibility)
+			if err != nil {
+				log.Println(err)
+
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Error updating paste",
+				})
+
+				return
+			}
+		}
+	}
+
+	// Updates skips nil fields, so clearing the expiry needs its own query.
+	if expirySet {
+		err = h.Pastes.UpdatePasteExpiry(pasteId, expiresAt)
 		if err != nil {
 			log.Println(err)
 
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Please provide valid data",
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error updating paste",
 			})
 
 			return
 		}
+	}
 
-		err = database.UpdatePasteRecord(pasteId, &paste)
+	if passwordSet {
+		err = h.Pastes.UpdatePastePassword(pasteId, passwordHash)
 		if err != nil {
 			log.Println(err)
 
@@ -474,24 +777,9 @@ func UpdatePasteController(c *gin.Context) {
 	})
 }
 
-func DeletePasteController(c *gin.Context) {
+func (h *Handler) DeletePasteController(c *gin.Context) {
 	log.Println("Inside DeletePasteController")
 
-	email, _ := c.Get("email")
-
-	user, err := database.GetUserByEmail(email.(string))
-	if err != nil {
-		log.Println(err)
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching user",
-		})
-
-		return
-	}
-
-	userId := user.ID
-
 	pasteId, found := c.Params.Get("id")
 	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -501,7 +789,7 @@ func DeletePasteController(c *gin.Context) {
 		return
 	}
 
-	paste, err := database.GetPasteByID(pasteId)
+	paste, err := h.Pastes.GetPasteByID(pasteId)
 	if err != nil {
 		log.Println(err)
 
@@ -512,50 +800,12 @@ func DeletePasteController(c *gin.Context) {
 		return
 	}
 
-	if paste.UserID != userId {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "You are not authorized to delete this paste",
-		})
-
-		return
-	}
-
-	err = fileupload.DeleteFile(pasteId)
-	if err != nil {
-		log.Println(err)
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error deleting file",
-		})
-
+	_, _, ok := h.authorizePasteEdit(c, paste, "delete", models.RoleCoOwner)
+	if !ok {
 		return
 	}
 
-	pasteAccesses, err := database.GetPasteAccessRecordsByPasteId(pasteId)
-	if err != nil {
-		log.Println(err)
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error fetching paste accesses",
-		})
-
-		return
-	}
-
-	for _, pasteAccess := range pasteAccesses {
-		err = database.DeletePasteAccessRecord(&pasteAccess)
-		if err != nil {
-			log.Println(err)
-
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error deleting paste access",
-			})
-
-			return
-		}
-	}
-
-	err = database.DeletePasteRecord(paste)
+	keys, err := h.Pastes.DeletePaste(paste)
 	if err != nil {
 		log.Println(err)
 
@@ -566,17 +816,19 @@ func DeletePasteController(c *gin.Context) {
 		return
 	}
 
+	h.deleteBlobs(keys...)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Paste deleted successfully!",
 	})
 }
 
-func CreatePasteAccessController(c *gin.Context) {
+func (h *Handler) CreatePasteAccessController(c *gin.Context) {
 	log.Println("Inside CreatePasteAccessController")
 
 	email, _ := c.Get("email")
 
-	owner, err := database.GetUserByEmail(email.(string))
+	owner, err := h.Users.GetUserByEmail(email.(string))
 	if err != nil {
 		log.Println(err)
 
@@ -598,7 +850,7 @@ func CreatePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	paste, err := database.GetPasteByID(pasteId)
+	paste, err := h.Pastes.GetPasteByID(pasteId)
 	if err != nil {
 		log.Println(err)
 
@@ -609,7 +861,18 @@ func CreatePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	if paste.UserID != ownerId {
+	allowed, err := h.hasPasteRole(ownerId, paste, models.RoleCoOwner)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	if !allowed {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"message": "You are not authorized to share this paste",
 		})
@@ -617,6 +880,18 @@ func CreatePasteAccessController(c *gin.Context) {
 		return
 	}
 
+	role, ok := parseGrantRole(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	teamId, found := c.GetQuery("team_id")
+	if found {
+		h.shareWithTeam(c, ownerId, paste, teamId, role)
+
+		return
+	}
+
 	userEmail, found := c.GetQuery("user_email")
 	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -626,7 +901,7 @@ func CreatePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	user, err := database.GetUserByEmail(userEmail)
+	user, err := h.Users.GetUserByEmail(userEmail)
 	if err != nil {
 		log.Println(err)
 
@@ -644,9 +919,16 @@ func CreatePasteAccessController(c *gin.Context) {
 	pasteAccess.ID = uuid.New()
 	pasteAccess.PasteID = pasteId
 	pasteAccess.UserID = userId
+	pasteAccess.Role = role
 
-	err = database.CreatePasteAccessRecord(&pasteAccess)
-	if err != nil {
+	err = h.Access.CreatePasteAccessRecord(&pasteAccess)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Paste is already shared with this user",
+		})
+
+		return
+	} else if err != nil {
 		log.Println(err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -662,12 +944,12 @@ func CreatePasteAccessController(c *gin.Context) {
 	})
 }
 
-func DeletePasteAccessController(c *gin.Context) {
+func (h *Handler) DeletePasteAccessController(c *gin.Context) {
 	log.Println("Inside DeletePasteAccessController")
 
 	email, _ := c.Get("email")
 
-	owner, err := database.GetUserByEmail(email.(string))
+	owner, err := h.Users.GetUserByEmail(email.(string))
 	if err != nil {
 		log.Println(err)
 
@@ -689,7 +971,7 @@ func DeletePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	paste, err := database.GetPasteByID(pasteId)
+	paste, err := h.Pastes.GetPasteByID(pasteId)
 	if err != nil {
 		log.Println(err)
 
@@ -700,7 +982,18 @@ func DeletePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	if paste.UserID != ownerId {
+	allowed, err := h.hasPasteRole(ownerId, paste, models.RoleCoOwner)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	if !allowed {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"message": "You are not authorized to share this paste",
 		})
@@ -708,6 +1001,13 @@ func DeletePasteAccessController(c *gin.Context) {
 		return
 	}
 
+	teamId, found := c.GetQuery("team_id")
+	if found {
+		h.unshareWithTeam(c, ownerId, paste, teamId)
+
+		return
+	}
+
 	userEmail, found := c.GetQuery("user_email")
 	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -717,7 +1017,7 @@ func DeletePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	user, err := database.GetUserByEmail(userEmail)
+	user, err := h.Users.GetUserByEmail(userEmail)
 	if err != nil {
 		log.Println(err)
 
@@ -730,10 +1030,16 @@ func DeletePasteAccessController(c *gin.Context) {
 
 	userId := user.ID
 
-	pasteAccess, err := database.GetPasteAccessRecordByUserIdAndPasteId(
+	pasteAccess, err := h.Access.GetPasteAccessRecordByUserIdAndPasteId(
 		userId, pasteId,
 	)
-	if err != nil {
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Paste is not shared with this user",
+		})
+
+		return
+	} else if err != nil {
 		log.Println(err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -743,7 +1049,15 @@ func DeletePasteAccessController(c *gin.Context) {
 		return
 	}
 
-	err = database.DeletePasteAccessRecord(pasteAccess)
+	if pasteAccess.AccessRole() == models.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "The owner's access can't be removed",
+		})
+
+		return
+	}
+
+	err = h.Access.DeletePasteAccessRecord(pasteAccess)
 	if err != nil {
 		log.Println(err)
 
@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -23,7 +26,15 @@ type LoginResponse struct {
 	RefreshToken string `json:"refreshtoken"`
 }
 
-func SignupController(c *gin.Context) {
+type RefreshPayload struct {
+	RefreshToken string `json:"refreshtoken" binding:"required"`
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+func (h *Handler) SignupController(c *gin.Context) {
 	var user models.User
 
 	err := c.ShouldBindJSON(&user)
@@ -53,7 +64,7 @@ func SignupController(c *gin.Context) {
 	user.Password = hashedPassword
 	user.ID = uuid.New()
 
-	err = database.CreateUserRecord(&user)
+	err = h.Users.CreateUserRecord(&user)
 	if err != nil {
 		log.Println(err)
 
@@ -70,9 +81,8 @@ func SignupController(c *gin.Context) {
 	})
 }
 
-func LoginController(c *gin.Context) {
+func (h *Handler) LoginController(c *gin.Context) {
 	var payload LoginPayload
-	var user models.User
 
 	err := c.ShouldBindJSON(&payload)
 	if err != nil {
@@ -86,17 +96,26 @@ func LoginController(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Where("email = ?", payload.Email).First(&user)
-	if result.Error == gorm.ErrRecordNotFound {
+	user, err := h.Users.GetUserByEmail(payload.Email)
+	if err == gorm.ErrRecordNotFound {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"Error": "Invalid User Credentials",
 		})
 		c.Abort()
 
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"Error": "Error Fetching User",
+		})
+		c.Abort()
+
 		return
 	}
 
-	err = database.CheckPassword(payload.Password, &user)
+	err = database.CheckPassword(payload.Password, user)
 	if err != nil {
 		log.Println(err)
 
@@ -108,26 +127,82 @@ func LoginController(c *gin.Context) {
 		return
 	}
 
-	jwt := auth.Jwt{
-		SecretKey:         "verysecretkey",
-		Issuer:            "AuthService",
-		ExpirationMinutes: 60,
-		ExpirationHours:   12,
+	tokenResponse, err := h.issueTokens(user.Email)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"Error": "Error Signing Token",
+		})
+		c.Abort()
+
+		return
 	}
 
-	signedToken, err := jwt.GenerateToken(user.Email)
+	c.JSON(http.StatusOK, tokenResponse)
+}
+
+func (h *Handler) RefreshController(c *gin.Context) {
+	var payload RefreshPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"Error": "Invalid Inputs",
+		})
+		c.Abort()
+
+		return
+	}
+
+	jwt := h.tokenIssuer()
+
+	claims, err := jwt.ValidateToken(payload.RefreshToken, auth.RefreshToken)
 	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"Error": "Invalid Refresh Token",
+		})
+		c.Abort()
+
+		return
+	}
+
+	// Revoking first makes each refresh token single use: if two requests
+	// race with the same token, only one insert into the denylist succeeds.
+	err = h.Users.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"Error": "Refresh Token Has Been Revoked",
+		})
+		c.Abort()
+
+		return
+	} else if err != nil {
 		log.Println(err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"Error": "Error Signing Token",
+			"Error": "Error Revoking Token",
 		})
 		c.Abort()
 
 		return
 	}
 
-	signedRefreshToken, err := jwt.RefreshToken(user.Email)
+	_, err = h.Users.GetUserByEmail(claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"Error": "Invalid User Credentials",
+		})
+		c.Abort()
+
+		return
+	}
+
+	tokenResponse, err := h.issueTokens(claims.Email)
 	if err != nil {
 		log.Println(err)
 
@@ -139,10 +214,95 @@ func LoginController(c *gin.Context) {
 		return
 	}
 
-	tokenResponse := LoginResponse{
+	c.JSON(http.StatusOK, tokenResponse)
+}
+
+func (h *Handler) LogoutController(c *gin.Context) {
+	var payload LogoutPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil && err != io.EOF {
+		log.Println(err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"Error": "Invalid Inputs",
+		})
+		c.Abort()
+
+		return
+	}
+
+	jti := c.GetString("jti")
+	expiresAt := c.GetTime("tokenExpiresAt")
+
+	err = h.Users.RevokeToken(jti, expiresAt)
+	if err != nil && !errors.Is(err, gorm.ErrDuplicatedKey) {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"Error": "Error Revoking Token",
+		})
+		c.Abort()
+
+		return
+	}
+
+	if payload.RefreshToken != "" {
+		jwt := h.tokenIssuer()
+
+		claims, err := jwt.ValidateToken(
+			payload.RefreshToken, auth.RefreshToken,
+		)
+		if err == nil && claims.Email == c.GetString("email") {
+			err = h.Users.RevokeToken(
+				claims.Id, time.Unix(claims.ExpiresAt, 0),
+			)
+			if err != nil && !errors.Is(err, gorm.ErrDuplicatedKey) {
+				log.Println(err)
+
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"Error": "Error Revoking Token",
+				})
+				c.Abort()
+
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"Message": "Successfully Logged Out",
+	})
+}
+
+func (h *Handler) tokenIssuer() auth.Jwt {
+	return auth.Jwt{
+		Keys:              h.Keys,
+		Issuer:            "AuthService",
+		ExpirationMinutes: 60,
+		ExpirationHours:   12,
+	}
+}
+
+func (h *Handler) issueTokens(email string) (*LoginResponse, error) {
+	jwt := h.tokenIssuer()
+
+	signedToken, err := jwt.GenerateToken(email)
+	if err != nil {
+		return nil, err
+	}
+
+	signedRefreshToken, err := jwt.RefreshToken(email)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
 		Token:        signedToken,
 		RefreshToken: signedRefreshToken,
-	}
+	}, nil
+}
 
-	c.JSON(http.StatusOK, tokenResponse)
+func (h *Handler) JWKSController(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Keys.JWKS())
 }
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/internal/reaper"
+)
+
+// An upload's blob is scheduled for deletion before it is written, and the
+// deletion is cancelled when its revision is committed. Blobs whose records
+// never make it are deleted once this has passed, so it has to outlast the
+// slowest upload.
+const abandonedUploadDelay = 24 * time.Hour
+
+// Burned blobs are deleted once they have been served. Their scheduled
+// deletion only catches servers that stop first, so it waits for slow
+// downloads to finish.
+const burnedBlobDelay = time.Hour
+
+// uploadBlob stores body under key, which must then be committed as a
+// revision. If the upload fails, whatever was written is deleted.
+func (h *Handler) uploadBlob(key string, body io.Reader) (*fileupload.FileInfo, error) {
+	err := h.Pastes.ScheduleBlobDeletions(
+		[]string{key}, time.Now().Add(abandonedUploadDelay),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := fileupload.UploadFile(h.Storage, key, body)
+	if err != nil {
+		h.deleteBlobs(key)
+
+		return nil, err
+	}
+
+	return fileInfo, nil
+}
+
+// deleteBlobs deletes scheduled blobs straight away. Any that fail are left
+// for the reaper to retry.
+func (h *Handler) deleteBlobs(keys ...string) {
+	err := reaper.DeleteBlobs(h.Pastes, h.Storage, keys)
+	if err != nil {
+		log.Println(err)
+	}
+}
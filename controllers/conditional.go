@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func pasteETag(paste *models.Paste) string {
+	if paste.ContentHash == "" {
+		return ""
+	}
+
+	return `"` + paste.ContentHash + `"`
+}
+
+func setValidators(c *gin.Context, paste *models.Paste) {
+	etag := pasteETag(paste)
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+
+	c.Header("Last-Modified", paste.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+func etagMatches(header string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since only
+// when no entity tags were sent, as RFC 9110 requires.
+func notModified(c *gin.Context, paste *models.Paste) bool {
+	ifNoneMatch := c.Request.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, pasteETag(paste))
+	}
+
+	ifModifiedSince := c.Request.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	return !paste.UpdatedAt.Truncate(time.Second).After(since)
+}
+
+// rangeApplies reports whether a Range header should be honoured given the
+// If-Range validator, if any.
+func rangeApplies(c *gin.Context, paste *models.Paste) bool {
+	ifRange := c.Request.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == pasteETag(paste)
+	}
+
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	return paste.UpdatedAt.Truncate(time.Second).Equal(date)
+}
+
+// parseRange parses a single "bytes=" range against a file of the given size.
+// Malformed and multiple ranges are ignored and yield ok == false, in which
+// case the whole file is served, as RFC 9110 allows. A well-formed range
+// that starts past the end yields errRangeNotSatisfiable.
+func parseRange(header string, size int64) (r byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		suffix, valid := parseRangePos(last)
+		if !valid {
+			return byteRange{}, false, nil
+		}
+
+		if suffix > size {
+			suffix = size
+		}
+
+		if suffix == 0 {
+			return byteRange{}, false, errRangeNotSatisfiable
+		}
+
+		return byteRange{start: size - suffix, length: suffix}, true, nil
+	}
+
+	start, valid := parseRangePos(first)
+	if !valid {
+		return byteRange{}, false, nil
+	}
+
+	end := size - 1
+
+	if last != "" {
+		end, valid = parseRangePos(last)
+		if !valid || end < start {
+			return byteRange{}, false, nil
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return byteRange{}, false, errRangeNotSatisfiable
+	}
+
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// parseRangePos parses a position in a range, which is only digits.
+func parseRangePos(pos string) (int64, bool) {
+	if pos == "" || strings.Trim(pos, "0123456789") != "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(pos, 10, 64)
+
+	return n, err == nil
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" +
+		strconv.FormatInt(r.start+r.length-1, 10) + "/" +
+		strconv.FormatInt(size, 10)
+}
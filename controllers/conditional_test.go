@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header string
+		want   byteRange
+		ok     bool
+		err    error
+	}{
+		// Open-ended and closed ranges, clipped to the file.
+		{"bytes=0-9", byteRange{start: 0, length: 10}, true, nil},
+		{"bytes=90-", byteRange{start: 90, length: 10}, true, nil},
+		{"bytes=99-99", byteRange{start: 99, length: 1}, true, nil},
+		{"bytes=95-200", byteRange{start: 95, length: 5}, true, nil},
+		{"bytes= 10-19 ", byteRange{start: 10, length: 10}, true, nil},
+
+		// Suffixes count back from the end, all of it if they're longer.
+		{"bytes=-10", byteRange{start: 90, length: 10}, true, nil},
+		{"bytes=-500", byteRange{start: 0, length: size}, true, nil},
+
+		// Ranges that select nothing can't be satisfied.
+		{"bytes=100-", byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=100-200", byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=-0", byteRange{}, false, errRangeNotSatisfiable},
+
+		// Anything malformed is ignored, serving the whole file.
+		{"bytes=", byteRange{}, false, nil},
+		{"bytes=-", byteRange{}, false, nil},
+		{"bytes=10", byteRange{}, false, nil},
+		{"bytes=abc-", byteRange{}, false, nil},
+		{"bytes=1-abc", byteRange{}, false, nil},
+		{"bytes=+1-5", byteRange{}, false, nil},
+		{"bytes=--5", byteRange{}, false, nil},
+		{"bytes=9-5", byteRange{}, false, nil},
+		{"bytes=99999999999999999999-", byteRange{}, false, nil},
+		{"items=0-9", byteRange{}, false, nil},
+		{"0-9", byteRange{}, false, nil},
+
+		// Multiple ranges aren't supported either.
+		{"bytes=0-9,20-29", byteRange{}, false, nil},
+		{"bytes=0-9, -5", byteRange{}, false, nil},
+	}
+
+	for _, test := range tests {
+		got, ok, err := parseRange(test.header, size)
+		if got != test.want || ok != test.ok || err != test.err {
+			t.Errorf(
+				"parseRange(%q) = %+v, %v, %v, want %+v, %v, %v",
+				test.header, got, ok, err, test.want, test.ok, test.err,
+			)
+		}
+	}
+
+	_, _, err := parseRange("bytes=-5", 0)
+	if err != errRangeNotSatisfiable {
+		t.Errorf("a suffix of an empty file = %v, want errRangeNotSatisfiable", err)
+	}
+}
+
+func TestByteRangeContentRange(t *testing.T) {
+	r := byteRange{start: 90, length: 10}
+
+	if got := r.contentRange(100); got != "bytes 90-99/100" {
+		t.Errorf("contentRange = %q, want %q", got, "bytes 90-99/100")
+	}
+}
+
+// conditionalContext returns a context for a request with the given headers,
+// as name and value pairs.
+func conditionalContext(headers ...string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		c.Request.Header.Set(headers[i], headers[i+1])
+	}
+
+	return c
+}
+
+func TestNotModified(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	paste := &models.Paste{ContentHash: "abc", UpdatedAt: updated}
+
+	before := updated.Add(-time.Hour).Format(http.TimeFormat)
+	at := updated.Format(http.TimeFormat)
+	after := updated.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    bool
+	}{
+		{"no validators", nil, false},
+		{"matching etag", []string{"If-None-Match", `"abc"`}, true},
+		{"weak matching etag", []string{"If-None-Match", `W/"abc"`}, true},
+		{"one of several etags", []string{"If-None-Match", `"x", "abc"`}, true},
+		{"any etag", []string{"If-None-Match", "*"}, true},
+		{"other etag", []string{"If-None-Match", `"xyz"`}, false},
+		{"unquoted etag", []string{"If-None-Match", "abc"}, false},
+		{"modified since", []string{"If-Modified-Since", before}, false},
+		{"not modified since", []string{"If-Modified-Since", at}, true},
+		{"not modified since later", []string{"If-Modified-Since", after}, true},
+		{"malformed date", []string{"If-Modified-Since", "yesterday"}, false},
+
+		// If-None-Match takes precedence, whatever the date says.
+		{
+			"etag differs, date matches",
+			[]string{"If-None-Match", `"xyz"`, "If-Modified-Since", after},
+			false,
+		},
+		{
+			"etag matches, date differs",
+			[]string{"If-None-Match", `"abc"`, "If-Modified-Since", before},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got := notModified(conditionalContext(test.headers...), paste)
+		if got != test.want {
+			t.Errorf("%s: notModified = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Pastes uploaded before hashes were recorded have no etag to match.
+	legacy := &models.Paste{UpdatedAt: updated}
+
+	if notModified(conditionalContext("If-None-Match", `""`), legacy) {
+		t.Error("an empty etag matched a paste without a hash")
+	}
+}
+
+func TestRangeApplies(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	paste := &models.Paste{ContentHash: "abc", UpdatedAt: updated}
+
+	tests := []struct {
+		name    string
+		ifRange string
+		want    bool
+	}{
+		{"no If-Range", "", true},
+		{"strong matching etag", `"abc"`, true},
+		{"other etag", `"xyz"`, false},
+		// A weak etag never validates a range, so the whole file is sent.
+		{"weak matching etag", `W/"abc"`, false},
+		{"exact date", updated.Format(http.TimeFormat), true},
+		{"other date", updated.Add(time.Hour).Format(http.TimeFormat), false},
+		{"malformed date", "yesterday", false},
+	}
+
+	for _, test := range tests {
+		var headers []string
+		if test.ifRange != "" {
+			headers = []string{"If-Range", test.ifRange}
+		}
+
+		got := rangeApplies(conditionalContext(headers...), paste)
+		if got != test.want {
+			t.Errorf("%s: rangeApplies = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
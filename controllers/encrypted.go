@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const encryptionHeader = "Pastey-Encryption"
+
+// The algorithms clients may encrypt pastes with. The server never sees the
+// key, so this is only recorded for clients to know how to decrypt.
+var encryptionAlgorithms = []string{"aes-128-gcm", "aes-256-gcm"}
+
+var errUnknownEncryption = errors.New("unknown encryption algorithm")
+
+// parseEncryption reads the Pastey-Encryption header, returning "" for
+// unencrypted content.
+func parseEncryption(c *gin.Context) (string, error) {
+	algorithm := strings.ToLower(c.Request.Header.Get(encryptionHeader))
+	if algorithm == "" {
+		return "", nil
+	}
+
+	if !slices.Contains(encryptionAlgorithms, algorithm) {
+		return "", errUnknownEncryption
+	}
+
+	return algorithm, nil
+}
+
+// Encrypted pastes are only ever ciphertext to the server, so there is
+// nothing to render, diff or index.
+func rejectEncrypted(c *gin.Context, paste *models.Paste) bool {
+	if !paste.Encrypted {
+		return false
+	}
+
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{
+		"message": "Paste is encrypted and can only be downloaded",
+	})
+
+	return true
+}
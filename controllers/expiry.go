@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidExpiry = errors.New("invalid expiry")
+
+// parseExpiresIn reads the Pastey-Expires-In header, which accepts a Go
+// duration ("90m", "24h"), a number of seconds, or "never" to clear the expiry.
+func parseExpiresIn(c *gin.Context) (expiresAt *time.Time, set bool, err error) {
+	value := c.Request.Header.Get("Pastey-Expires-In")
+	if value == "" {
+		return nil, false, nil
+	}
+
+	if value == "never" {
+		return nil, true, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, false, errInvalidExpiry
+		}
+
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	if ttl <= 0 {
+		return nil, false, errInvalidExpiry
+	}
+
+	expiry := time.Now().Add(ttl)
+
+	return &expiry, true, nil
+}
+
+func validateExpiresAt(expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errInvalidExpiry
+	}
+
+	return nil
+}
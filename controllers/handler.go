@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+)
+
+// Handler serves the API from the stores and storage it is given, so the
+// server can run on any database and storage backend.
+type Handler struct {
+	Users  database.UserStore
+	Pastes database.PasteStore
+	Access database.AccessStore
+
+	Storage fileupload.Storage
+	// Keys sign the tokens issued at login.
+	Keys *auth.KeySet
+	// ShareLinks sign the tokens of new share links.
+	ShareLinks *auth.ShareLinkSigner
+
+	passwords *passwordLimiter
+}
+
+func NewHandler(
+	store *database.Store, storage fileupload.Storage,
+	keys *auth.KeySet, shareLinks *auth.ShareLinkSigner,
+) *Handler {
+	return &Handler{
+		Users:  store.Users,
+		Pastes: store.Pastes,
+		Access: store.Access,
+
+		Storage:    storage,
+		Keys:       keys,
+		ShareLinks: shareLinks,
+
+		passwords: newPasswordLimiter(),
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/highlight"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pasteCursor is the opaque cursor handed to clients. It records the sort it
+// was made for, so it can't be reused with a different one.
+type pasteCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         string `json:"id"`
+}
+
+func encodePasteCursor(paste *models.Paste, sort string, descending bool) string {
+	cursor := pasteCursor{Sort: sort, Descending: descending, ID: paste.ID}
+
+	switch sort {
+	case database.SortCreatedAt:
+		cursor.Value = paste.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case database.SortUpdatedAt:
+		cursor.Value = paste.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	case database.SortTitle:
+		cursor.Value = paste.Title
+	}
+
+	data, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePasteCursor(value string, sort string, descending bool) (
+	*database.PasteCursor, error,
+) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor pasteCursor
+
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != sort || cursor.Descending != descending {
+		return nil, errInvalidCursor
+	}
+
+	if sort == database.SortTitle {
+		return &database.PasteCursor{Value: cursor.Value, ID: cursor.ID}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, cursor.Value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &database.PasteCursor{Value: at, ID: cursor.ID}, nil
+}
+
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &at, nil
+}
+
+// parsePasteListOptions reads the listing query parameters:
+//   - owned=true lists only the caller's pastes, owned=false only those
+//     shared with them
+//   - visibility, language, title_prefix, and created_after and
+//     created_before as RFC 3339 times filter the pastes
+//   - sort is created_at (default), updated_at or title, and order is desc
+//     (default) or asc
+//   - limit is the page size, and cursor the next_cursor of the last page
+//
+// If they are invalid, it writes the error response and returns nil.
+func parsePasteListOptions(c *gin.Context, userId uuid.UUID) *database.PasteListOptions {
+	opts := database.PasteListOptions{
+		UserID: userId,
+		Sort:   c.DefaultQuery("sort", database.SortCreatedAt),
+		Limit:  defaultPageSize,
+	}
+
+	fail := func(message string) *database.PasteListOptions {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": message,
+		})
+
+		return nil
+	}
+
+	if !database.ValidPasteSort(opts.Sort) {
+		return fail("Sort must be created_at, updated_at or title")
+	}
+
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		return fail("Order must be asc or desc")
+	}
+
+	opts.Descending = order == "desc"
+
+	owned := c.Query("owned")
+	if owned != "" {
+		value, err := strconv.ParseBool(owned)
+		if err != nil {
+			return fail("Invalid owned value")
+		}
+
+		opts.Owned = &value
+	}
+
+	visibility := c.Query("visibility")
+	if visibility != "" {
+		value, err := models.ParseVisibility(visibility)
+		if err != nil {
+			return fail("Invalid visibility value")
+		}
+
+		opts.Visibility = &value
+	}
+
+	language := c.Query("language")
+	if language != "" {
+		value, ok := highlight.Normalize(language)
+		if !ok {
+			return fail("Unknown language")
+		}
+
+		opts.Language = value
+	}
+
+	var err error
+
+	opts.CreatedAfter, err = parseTimeQuery(c, "created_after")
+	if err != nil {
+		return fail("Invalid created_after value")
+	}
+
+	opts.CreatedBefore, err = parseTimeQuery(c, "created_before")
+	if err != nil {
+		return fail("Invalid created_before value")
+	}
+
+	opts.TitlePrefix = c.Query("title_prefix")
+
+	limit := c.Query("limit")
+	if limit != "" {
+		opts.Limit, err = strconv.Atoi(limit)
+		if err != nil || opts.Limit < 1 || opts.Limit > maxPageSize {
+			return fail("Limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+	}
+
+	cursor := c.Query("cursor")
+	if cursor != "" {
+		opts.After, err = decodePasteCursor(cursor, opts.Sort, opts.Descending)
+		if err != nil {
+			return fail("Invalid cursor")
+		}
+	}
+
+	return &opts
+}
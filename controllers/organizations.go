@@ -0,0 +1,774 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+type OrganizationPayload struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type MemberPayload struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role"`
+}
+
+type TeamPayload struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// getOrganizationMembership fetches the caller's membership of the
+// organization named by the :orgId parameter. Organizations the caller isn't
+// a member of are reported as not found. If there's no membership, it writes
+// the error response and returns nil.
+func (h *Handler) getOrganizationMembership(c *gin.Context) *models.OrganizationMembership {
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return nil
+	}
+
+	organizationId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid organization ID",
+		})
+
+		return nil
+	}
+
+	membership, err := h.Access.GetOrganizationMembership(organizationId, user.ID)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Organization not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching organization",
+		})
+
+		return nil
+	}
+
+	return membership
+}
+
+func requireOrganizationAdmin(
+	c *gin.Context, membership *models.OrganizationMembership,
+) bool {
+	if membership.Role == models.OrganizationAdmin {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"message": "Only organization admins can do this",
+	})
+
+	return false
+}
+
+// getTeamParam fetches the team named by the :teamId parameter, which must
+// belong to the organization. If it can't, it writes the error response and
+// returns nil.
+func (h *Handler) getTeamParam(c *gin.Context, organizationId uuid.UUID) *models.Team {
+	teamId, err := uuid.Parse(c.Param("teamId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid team ID",
+		})
+
+		return nil
+	}
+
+	team, err := h.Access.GetTeamByID(teamId)
+	if err == gorm.ErrRecordNotFound || (err == nil &&
+		team.OrganizationID != organizationId) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Team not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching team",
+		})
+
+		return nil
+	}
+
+	return team
+}
+
+// getMemberParam parses the :userId parameter.
+func getMemberParam(c *gin.Context) (uuid.UUID, bool) {
+	userId, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid user ID",
+		})
+
+		return uuid.Nil, false
+	}
+
+	return userId, true
+}
+
+// getUserByPayloadEmail looks up the user a member payload names. If there
+// is no such user, it writes the error response and returns nil.
+func (h *Handler) getUserByPayloadEmail(c *gin.Context, email string) *models.User {
+	user, err := h.Users.GetUserByEmail(email)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return nil
+	}
+
+	return user
+}
+
+// getShareableTeam fetches a team a paste is being shared with. Pastes can
+// only be shared with teams of organizations the sharer belongs to.
+func (h *Handler) getShareableTeam(
+	c *gin.Context, userId uuid.UUID, teamIdString string,
+) *models.Team {
+	teamId, err := uuid.Parse(teamIdString)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid team ID",
+		})
+
+		return nil
+	}
+
+	team, err := h.Access.GetTeamByID(teamId)
+	if err == nil {
+		_, err = h.Access.GetOrganizationMembership(team.OrganizationID, userId)
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Team not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching team",
+		})
+
+		return nil
+	}
+
+	return team
+}
+
+func (h *Handler) shareWithTeam(
+	c *gin.Context, userId uuid.UUID, paste *models.Paste, teamId string,
+	role string,
+) {
+	team := h.getShareableTeam(c, userId, teamId)
+	if team == nil {
+		return
+	}
+
+	access := models.TeamPasteAccess{
+		ID:      uuid.New(),
+		TeamID:  team.ID,
+		PasteID: paste.ID,
+		Role:    role,
+	}
+
+	err := h.Access.CreateTeamPasteAccessRecord(&access)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Paste is already shared with this team",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating paste access",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Paste access created successfully!",
+		"data":    access,
+	})
+}
+
+func (h *Handler) unshareWithTeam(
+	c *gin.Context, userId uuid.UUID, paste *models.Paste, teamId string,
+) {
+	team := h.getShareableTeam(c, userId, teamId)
+	if team == nil {
+		return
+	}
+
+	err := h.Access.DeleteTeamPasteAccess(team.ID, paste.ID)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Paste is not shared with this team",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting paste access",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Paste access deleted successfully!",
+	})
+}
+
+func (h *Handler) CreateOrganizationController(c *gin.Context) {
+	log.Println("Inside CreateOrganizationController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	var payload OrganizationPayload
+
+	err = c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide a name",
+		})
+
+		return
+	}
+
+	organization := models.Organization{
+		ID:   uuid.New(),
+		Name: payload.Name,
+	}
+
+	err = h.Access.CreateOrganizationRecord(&organization, user.ID)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "An organization with this name already exists",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating organization",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Organization created successfully!",
+		"data":    organization,
+	})
+}
+
+func (h *Handler) GetOrganizationsController(c *gin.Context) {
+	log.Println("Inside GetOrganizationsController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	organizations, err := h.Access.GetOrganizationsByUserId(user.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching organizations",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Organizations of user with ID: " + user.ID.String(),
+		"data":    organizations,
+	})
+}
+
+func (h *Handler) GetOrganizationMembersController(c *gin.Context) {
+	log.Println("Inside GetOrganizationMembersController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil {
+		return
+	}
+
+	memberships, err := h.Access.GetOrganizationMemberships(
+		membership.OrganizationID,
+	)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching members",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Members of organization with ID: " +
+			membership.OrganizationID.String(),
+		"data": memberships,
+	})
+}
+
+func (h *Handler) AddOrganizationMemberController(c *gin.Context) {
+	log.Println("Inside AddOrganizationMemberController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil || !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	var payload MemberPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide the email of the user",
+		})
+
+		return
+	}
+
+	if payload.Role == "" {
+		payload.Role = models.OrganizationMember
+	}
+
+	if payload.Role != models.OrganizationMember &&
+		payload.Role != models.OrganizationAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid role",
+		})
+
+		return
+	}
+
+	user := h.getUserByPayloadEmail(c, payload.Email)
+	if user == nil {
+		return
+	}
+
+	member := models.OrganizationMembership{
+		ID:             uuid.New(),
+		OrganizationID: membership.OrganizationID,
+		UserID:         user.ID,
+		Role:           payload.Role,
+	}
+
+	err = h.Access.CreateOrganizationMembershipRecord(&member)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "User is already a member of this organization",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error adding member",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Member added successfully!",
+		"data":    member,
+	})
+}
+
+func (h *Handler) RemoveOrganizationMemberController(c *gin.Context) {
+	log.Println("Inside RemoveOrganizationMemberController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil {
+		return
+	}
+
+	userId, ok := getMemberParam(c)
+	if !ok {
+		return
+	}
+
+	// Anyone may leave, but only admins may remove others.
+	if userId != membership.UserID && !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	memberships, err := h.Access.GetOrganizationMemberships(
+		membership.OrganizationID,
+	)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching members",
+		})
+
+		return
+	}
+
+	admins := 0
+	removingAdmin := false
+
+	for _, member := range memberships {
+		if member.Role == models.OrganizationAdmin {
+			admins++
+
+			if member.UserID == userId {
+				removingAdmin = true
+			}
+		}
+	}
+
+	if removingAdmin && admins == 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "An organization must keep at least one admin",
+		})
+
+		return
+	}
+
+	err = h.Access.DeleteOrganizationMembership(membership.OrganizationID, userId)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Member not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error removing member",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member removed successfully!",
+	})
+}
+
+func (h *Handler) CreateTeamController(c *gin.Context) {
+	log.Println("Inside CreateTeamController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil || !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	var payload TeamPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide a name",
+		})
+
+		return
+	}
+
+	team := models.Team{
+		ID:             uuid.New(),
+		OrganizationID: membership.OrganizationID,
+		Name:           payload.Name,
+	}
+
+	err = h.Access.CreateTeamRecord(&team)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "A team with this name already exists",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating team",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Team created successfully!",
+		"data":    team,
+	})
+}
+
+func (h *Handler) GetTeamsController(c *gin.Context) {
+	log.Println("Inside GetTeamsController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil {
+		return
+	}
+
+	teams, err := h.Access.GetTeamsByOrganizationId(membership.OrganizationID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching teams",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Teams of organization with ID: " +
+			membership.OrganizationID.String(),
+		"data": teams,
+	})
+}
+
+func (h *Handler) DeleteTeamController(c *gin.Context) {
+	log.Println("Inside DeleteTeamController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil || !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	team := h.getTeamParam(c, membership.OrganizationID)
+	if team == nil {
+		return
+	}
+
+	err := h.Access.DeleteTeamRecord(team)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting team",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Team deleted successfully!",
+	})
+}
+
+func (h *Handler) GetTeamMembersController(c *gin.Context) {
+	log.Println("Inside GetTeamMembersController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil {
+		return
+	}
+
+	team := h.getTeamParam(c, membership.OrganizationID)
+	if team == nil {
+		return
+	}
+
+	memberships, err := h.Access.GetTeamMemberships(team.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching members",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Members of team with ID: " + team.ID.String(),
+		"data":    memberships,
+	})
+}
+
+func (h *Handler) AddTeamMemberController(c *gin.Context) {
+	log.Println("Inside AddTeamMemberController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil || !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	team := h.getTeamParam(c, membership.OrganizationID)
+	if team == nil {
+		return
+	}
+
+	var payload MemberPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide the email of the user",
+		})
+
+		return
+	}
+
+	user := h.getUserByPayloadEmail(c, payload.Email)
+	if user == nil {
+		return
+	}
+
+	_, err = h.Access.GetOrganizationMembership(team.OrganizationID, user.ID)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "User is not a member of this organization",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching organization",
+		})
+
+		return
+	}
+
+	member := models.TeamMembership{
+		ID:     uuid.New(),
+		TeamID: team.ID,
+		UserID: user.ID,
+	}
+
+	err = h.Access.CreateTeamMembershipRecord(&member)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "User is already a member of this team",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error adding member",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Member added successfully!",
+		"data":    member,
+	})
+}
+
+func (h *Handler) RemoveTeamMemberController(c *gin.Context) {
+	log.Println("Inside RemoveTeamMemberController")
+
+	membership := h.getOrganizationMembership(c)
+	if membership == nil {
+		return
+	}
+
+	team := h.getTeamParam(c, membership.OrganizationID)
+	if team == nil {
+		return
+	}
+
+	userId, ok := getMemberParam(c)
+	if !ok {
+		return
+	}
+
+	if userId != membership.UserID && !requireOrganizationAdmin(c, membership) {
+		return
+	}
+
+	err := h.Access.DeleteTeamMembership(team.ID, userId)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Member not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error removing member",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member removed successfully!",
+	})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const (
+	passwordHeader      = "Pastey-Password"
+	setPasswordHeader   = "Pastey-Set-Password"
+	clearPasswordHeader = "Pastey-Clear-Password"
+
+	maxPasswordAttempts   = 5
+	passwordAttemptWindow = 15 * time.Minute
+)
+
+// parseSetPassword reads the password headers of a create or update request
+// and hashes the new password. An empty hash with set true clears the
+// password.
+func parseSetPassword(c *gin.Context) (hash string, set bool, err error) {
+	clear := c.Request.Header.Get(clearPasswordHeader)
+	if clear != "" {
+		cleared, err := strconv.ParseBool(clear)
+		if err != nil {
+			return "", false, err
+		}
+
+		if cleared {
+			return "", true, nil
+		}
+	}
+
+	password := c.Request.Header.Get(setPasswordHeader)
+	if password == "" {
+		return "", false, nil
+	}
+
+	hash, err = database.HashPassword(password)
+	if err != nil {
+		return "", false, err
+	}
+
+	return hash, true, nil
+}
+
+type passwordAttempts struct {
+	failures int
+	since    time.Time
+}
+
+// passwordLimiter counts failed password attempts per client and paste, so
+// one client can't brute force a password, nor lock everyone else out of a
+// paste by failing on purpose. Attempts are only counted in memory: the
+// limit holds for a single server, and behind several replicas each one
+// allows its own attempts.
+type passwordLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*passwordAttempts
+	pruned   time.Time
+}
+
+func newPasswordLimiter() *passwordLimiter {
+	return &passwordLimiter{attempts: map[string]*passwordAttempts{}}
+}
+
+func passwordAttemptKey(client string, pasteId string) string {
+	return client + " " + pasteId
+}
+
+// current returns the attempts counted in the window still open for key,
+// or nil if there are none. The caller holds the lock.
+func (l *passwordLimiter) current(key string, now time.Time) *passwordAttempts {
+	attempts, ok := l.attempts[key]
+	if !ok {
+		return nil
+	}
+
+	if !now.Before(attempts.since.Add(passwordAttemptWindow)) {
+		delete(l.attempts, key)
+
+		return nil
+	}
+
+	return attempts
+}
+
+// prune forgets attempts whose window has passed, including those of
+// clients that never came back. It sweeps the map at most once a window, so
+// entries outlive their window by at most one more. The caller holds the
+// lock.
+func (l *passwordLimiter) prune(now time.Time) {
+	if now.Before(l.pruned.Add(passwordAttemptWindow)) {
+		return
+	}
+
+	l.pruned = now
+
+	for key, attempts := range l.attempts {
+		if !now.Before(attempts.since.Add(passwordAttemptWindow)) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// retryAfter returns how long until client may try the password of pasteId
+// again, or 0 if it can try now.
+func (l *passwordLimiter) retryAfter(
+	client string, pasteId string, now time.Time,
+) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	attempts := l.current(passwordAttemptKey(client, pasteId), now)
+	if attempts == nil || attempts.failures < maxPasswordAttempts {
+		return 0
+	}
+
+	return attempts.since.Add(passwordAttemptWindow).Sub(now)
+}
+
+func (l *passwordLimiter) fail(client string, pasteId string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	key := passwordAttemptKey(client, pasteId)
+
+	attempts := l.current(key, now)
+	if attempts == nil {
+		attempts = &passwordAttempts{since: now}
+		l.attempts[key] = attempts
+	}
+
+	attempts.failures++
+}
+
+// checkPastePassword makes the caller supply the password of a protected
+// paste in the Pastey-Password header. Reads have no body to send it in, and
+// a query parameter would end up in logs. Editors and above never need it. If
+// the password is missing or wrong, it writes the error response and returns
+// false.
+func (h *Handler) checkPastePassword(c *gin.Context, paste *models.Paste) bool {
+	if paste.PasswordHash == "" {
+		return true
+	}
+
+	c.Header("Cache-Control", "private")
+
+	email, found := c.Get("email")
+	if found && !paste.Anonymous() {
+		user, err := h.Users.GetUserByEmail(email.(string))
+		if err == nil {
+			edits, err := h.hasPasteRole(user.ID, paste, models.RoleEditor)
+			if err == nil && edits {
+				return true
+			}
+		}
+	}
+
+	password := c.Request.Header.Get(passwordHeader)
+	if password == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "This paste is password protected",
+		})
+
+		return false
+	}
+
+	now := time.Now()
+
+	client := c.ClientIP()
+
+	wait := h.passwords.retryAfter(client, paste.ID, now)
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many incorrect passwords, try again later",
+		})
+
+		return false
+	}
+
+	err := database.CheckPastePassword(password, paste)
+	if err != nil {
+		h.passwords.fail(client, paste.ID, now)
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Incorrect password",
+		})
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/middlewares"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func TestPasswordLimiter(t *testing.T) {
+	limiter := newPasswordLimiter()
+	now := time.Now()
+
+	for i := 0; i < maxPasswordAttempts; i++ {
+		if wait := limiter.retryAfter("attacker", "paste", now); wait != 0 {
+			t.Fatalf("blocked after %d failures", i)
+		}
+
+		limiter.fail("attacker", "paste", now)
+	}
+
+	if wait := limiter.retryAfter("attacker", "paste", now); wait != passwordAttemptWindow {
+		t.Errorf("retryAfter = %v, want %v", wait, passwordAttemptWindow)
+	}
+
+	// Failures are counted per client and paste.
+	if wait := limiter.retryAfter("reader", "paste", now); wait != 0 {
+		t.Errorf("another client has to wait %v", wait)
+	}
+
+	if wait := limiter.retryAfter("attacker", "other paste", now); wait != 0 {
+		t.Errorf("another paste has to wait %v", wait)
+	}
+
+	later := now.Add(passwordAttemptWindow)
+
+	if wait := limiter.retryAfter("attacker", "paste", later); wait != 0 {
+		t.Errorf("still blocked %v after the window", wait)
+	}
+}
+
+func TestPasswordLimiterForgetsIdleClients(t *testing.T) {
+	limiter := newPasswordLimiter()
+	now := time.Now()
+
+	limiter.fail("first", "paste", now)
+	limiter.fail("second", "paste", now.Add(passwordAttemptWindow/2))
+
+	// The sweep runs at most once a window, and only drops expired entries.
+	limiter.retryAfter("someone", "paste", now.Add(passwordAttemptWindow))
+
+	if _, ok := limiter.attempts[passwordAttemptKey("first", "paste")]; ok {
+		t.Error("an expired entry was kept")
+	}
+
+	if _, ok := limiter.attempts[passwordAttemptKey("second", "paste")]; !ok {
+		t.Error("an entry still in its window was dropped")
+	}
+
+	limiter.retryAfter("someone", "paste", now.Add(2*passwordAttemptWindow))
+
+	if len(limiter.attempts) != 0 {
+		t.Errorf("%d entries are left after every window passed", len(limiter.attempts))
+	}
+}
+
+func TestEditorsReadProtectedPastesWithoutPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("JWT_ALGORITHM", "HS256")
+	t.Setenv("JWT_KEY_ID", "test")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_SECRET_FILE", "")
+	t.Setenv("JWT_VERIFICATION_KEYS_DIR", "")
+
+	keys, err := auth.LoadKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory := database.NewMemory()
+	store := &database.Store{Users: memory, Pastes: memory, Access: memory}
+	storage := fileupload.NewMemoryStorage()
+	h := NewHandler(store, storage, keys, auth.NewShareLinkSigner([]byte("secret")))
+
+	users := map[string]*models.User{}
+
+	for _, name := range []string{"owner", "editor", "reader"} {
+		users[name] = &models.User{ID: uuid.New(), Email: name + "@example.com"}
+
+		err = memory.CreateUserRecord(users[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paste := models.Paste{
+		ID:           "protected",
+		Visibility:   models.Public,
+		UserID:       users["owner"].ID,
+		PasswordHash: string(hash),
+	}
+	revision := models.PasteRevision{ID: uuid.New(), PasteID: paste.ID}
+	revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+	paste.BlobKey = revision.BlobKey
+
+	_, err = fileupload.UploadFile(storage, revision.BlobKey, strings.NewReader("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = memory.CreatePaste(&paste, &revision, &models.PasteAccess{
+		ID: uuid.New(), PasteID: paste.ID, UserID: users["owner"].ID, Role: models.RoleOwner,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = memory.CreatePasteAccessRecord(&models.PasteAccess{
+		ID: uuid.New(), PasteID: paste.ID, UserID: users["editor"].ID, Role: models.RoleEditor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := h.tokenIssuer()
+
+	r := gin.New()
+	r.GET(
+		"/paste/:id/file",
+		middlewares.Authz(store, keys, h.ShareLinks), h.GetPasteFileController,
+	)
+
+	for name, want := range map[string]int{
+		"owner":  http.StatusOK,
+		"editor": http.StatusOK,
+		"reader": http.StatusUnauthorized,
+		"":       http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", "/paste/protected/file", nil)
+
+		if name != "" {
+			token, err := issuer.GenerateToken(users[name].Email)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("%q reading without a password: got %d, want %d", name, rec.Code, want)
+		}
+	}
+}
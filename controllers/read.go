@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// getReadablePaste fetches the paste named by the :id parameter and checks
+// that the caller may read it. If not, it writes the error response and
+// returns nil.
+func (h *Handler) getReadablePaste(c *gin.Context) *models.Paste {
+	pasteId, found := c.Params.Get("id")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide the ID of the paste",
+		})
+
+		return nil
+	}
+
+	paste, err := h.Pastes.GetPasteByID(pasteId)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Paste not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste",
+		})
+
+		return nil
+	}
+
+	if paste.Expired() {
+		c.JSON(http.StatusGone, gin.H{
+			"message": "This paste has expired",
+		})
+
+		return nil
+	}
+
+	// A share link grants access on its own, whatever the visibility.
+	if !paste.Visibility.Open() && shareLinkRole(c) == "" {
+		email, found := c.Get("email")
+		if !found {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "You are not authorized to view this paste",
+			})
+
+			return nil
+		}
+
+		user, err := h.Users.GetUserByEmail(email.(string))
+		if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error fetching user",
+			})
+
+			return nil
+		}
+
+		role, err := h.pasteRole(user.ID, paste)
+		if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error fetching paste access",
+			})
+
+			return nil
+		}
+
+		if role == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "You are not authorized to view this paste",
+			})
+
+			return nil
+		}
+	}
+
+	if !h.checkPastePassword(c, paste) {
+		return nil
+	}
+
+	return paste
+}
+
+// handleConditional sets the caching headers for a paste's content and
+// answers conditional requests, reporting whether a response was written.
+// Burn-after-reading pastes are never cached.
+func handleConditional(c *gin.Context, paste *models.Paste) bool {
+	if paste.BurnAfterReading {
+		c.Header("Cache-Control", "no-store")
+
+		return false
+	}
+
+	setValidators(c, paste)
+
+	if notModified(c, paste) {
+		c.Status(http.StatusNotModified)
+
+		return true
+	}
+
+	return false
+}
+
+// openPasteFile opens a paste's content for a full read. Burn-after-reading
+// pastes aren't claimed here: the caller claims them once nothing else can
+// stop the content being served. If the file can't be opened, it writes the
+// error response and returns nil.
+func (h *Handler) openPasteFile(c *gin.Context, paste *models.Paste) (io.ReadCloser, int64) {
+	file, filesize, err := fileupload.GetFile(h.Storage, paste.StorageKey())
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching file",
+		})
+
+		return nil, 0
+	}
+
+	return file, filesize
+}
+
+// claimBurnAfterReading claims a burn-after-reading paste for this request
+// and returns the keys of its blobs, to be deleted once the content has been
+// served. The paste is gone once claimed, so its content must already have
+// been opened.
+// If the paste can't be claimed, it writes the error response and returns
+// false.
+func (h *Handler) claimBurnAfterReading(c *gin.Context, paste *models.Paste) (
+	[]string, bool,
+) {
+	keys, err := h.Pastes.GetPasteBlobKeys(paste)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste",
+		})
+
+		return nil, false
+	}
+
+	// The blob is opened before claiming so that a concurrent reader which
+	// loses the claim never sees the content, and the winner can still serve
+	// it after the records are gone.
+	claimed, err := h.Pastes.ClaimBurnAfterReadingPaste(
+		paste.ID, time.Now().Add(burnedBlobDelay),
+	)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste",
+		})
+
+		return nil, false
+	}
+
+	if !claimed {
+		c.JSON(http.StatusGone, gin.H{
+			"message": "This paste has already been read",
+		})
+
+		return nil, false
+	}
+
+	return keys, true
+}
+
+// claimPasteFile claims a burn-after-reading paste whose file is open and
+// returns the file, which deletes the paste's blobs when closed. Other
+// pastes' files are returned as they are. If the paste can't be claimed, it
+// closes the file, writes the error response and returns nil.
+func (h *Handler) claimPasteFile(
+	c *gin.Context, paste *models.Paste, file io.ReadCloser,
+) io.ReadCloser {
+	if !paste.BurnAfterReading {
+		return file
+	}
+
+	keys, ok := h.claimBurnAfterReading(c, paste)
+	if !ok {
+		file.Close()
+
+		return nil
+	}
+
+	return &burnOnClose{ReadCloser: file, handler: h, keys: keys}
+}
+
+type burnOnClose struct {
+	io.ReadCloser
+	handler *Handler
+	keys    []string
+}
+
+func (b *burnOnClose) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.handler.deleteBlobs(b.keys...)
+
+	return err
+}
+
+func (h *Handler) servePasteRange(c *gin.Context, paste *models.Paste, r byteRange) {
+	file, err := fileupload.GetFileRange(
+		h.Storage, paste.StorageKey(), r.start, r.length,
+	)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching file",
+		})
+
+		return
+	}
+
+	defer file.Close()
+
+	c.DataFromReader(
+		http.StatusPartialContent, r.length, "application/octet-stream", file,
+		map[string]string{"Content-Range": r.contentRange(paste.Size)},
+	)
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/internal/highlight"
+)
+
+const sniffLen = 8192
+
+const maxRenderSize = 2 << 20
+
+var errUnknownLanguage = errors.New("unknown language")
+
+// resolveLanguage normalizes an explicitly requested language, or detects one
+// from the upload when none was given.
+func resolveLanguage(explicit string, filename string, head []byte) (
+	string, error,
+) {
+	if explicit != "" {
+		language, ok := highlight.Normalize(explicit)
+		if !ok {
+			return "", errUnknownLanguage
+		}
+
+		return language, nil
+	}
+
+	return highlight.Detect(filename, head), nil
+}
+
+func (h *Handler) GetPasteRawController(c *gin.Context) {
+	log.Println("Inside GetPasteRawController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil || rejectEncrypted(c, paste) {
+		return
+	}
+
+	if handleConditional(c, paste) {
+		return
+	}
+
+	file, filesize := h.openPasteFile(c, paste)
+	if file == nil {
+		return
+	}
+
+	// A burn-after-reading paste is only claimed once its content has
+	// started coming in, so a failing read doesn't destroy it unread.
+	reader := bufio.NewReaderSize(file, sniffLen)
+
+	head, err := reader.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		log.Println(err)
+
+		file.Close()
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error reading file",
+		})
+
+		return
+	}
+
+	file = h.claimPasteFile(c, paste, file)
+	if file == nil {
+		return
+	}
+
+	defer file.Close()
+
+	c.DataFromReader(
+		http.StatusOK, filesize,
+		highlight.ContentType(paste.Language, head), reader,
+		map[string]string{"X-Content-Type-Options": "nosniff"},
+	)
+}
+
+func (h *Handler) GetPasteViewController(c *gin.Context) {
+	log.Println("Inside GetPasteViewController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil || rejectEncrypted(c, paste) {
+		return
+	}
+
+	if paste.Size > maxRenderSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"message": "Paste is too large to render, use the raw endpoint",
+		})
+
+		return
+	}
+
+	if handleConditional(c, paste) {
+		return
+	}
+
+	file, _ := h.openPasteFile(c, paste)
+	if file == nil {
+		return
+	}
+
+	content, err := io.ReadAll(io.LimitReader(file, maxRenderSize+1))
+	file.Close()
+
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error reading file",
+		})
+
+		return
+	}
+
+	if len(content) > maxRenderSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"message": "Paste is too large to render, use the raw endpoint",
+		})
+
+		return
+	}
+
+	if !highlight.IsText(content) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"message": "Paste is not text and can't be rendered",
+		})
+
+		return
+	}
+
+	var page bytes.Buffer
+
+	err = highlight.Render(&page, paste.Title, paste.Language, string(content))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error rendering paste",
+		})
+
+		return
+	}
+
+	// A burn-after-reading paste is only claimed once it has rendered, so
+	// one that can't be viewed can still be downloaded.
+	if paste.BurnAfterReading {
+		keys, ok := h.claimBurnAfterReading(c, paste)
+		if !ok {
+			return
+		}
+
+		defer h.deleteBlobs(keys...)
+	}
+
+	c.Header(
+		"Content-Security-Policy",
+		"default-src 'none'; style-src 'unsafe-inline'",
+	)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/internal/diff"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/internal/highlight"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const diffContextLines = 3
+
+// uploadPasteRevision stores body under a new revision key, makes it the
+// paste's current content and reindexes it for search under the paste's
+// title. Earlier revisions are left untouched.
+func (h *Handler) uploadPasteRevision(
+	paste *models.Paste, userId uuid.UUID, language string, body io.Reader,
+) (*models.PasteRevision, error) {
+	revision := models.PasteRevision{
+		ID:       uuid.New(),
+		PasteID:  paste.ID,
+		UserID:   userId,
+		Language: language,
+	}
+	revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+
+	capture := &searchCapture{}
+
+	fileInfo, err := h.uploadBlob(
+		revision.BlobKey, io.TeeReader(body, capture),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Size = fileInfo.Size
+	revision.ContentHash = fileInfo.SHA256
+
+	err = h.Pastes.CommitPasteRevision(&revision)
+	if err != nil {
+		h.deleteBlobs(revision.BlobKey)
+
+		return nil, err
+	}
+
+	h.indexPaste(paste, capture)
+
+	return &revision, nil
+}
+
+// getRevisionParam fetches the revision of paste named by the given path or
+// query parameter, writing the error response and returning nil if it can't.
+func (h *Handler) getRevisionParam(
+	c *gin.Context, paste *models.Paste, value string,
+) *models.PasteRevision {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid revision number",
+		})
+
+		return nil
+	}
+
+	revision, err := h.Pastes.GetPasteRevision(paste.ID, number)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Revision not found",
+		})
+
+		return nil
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching revision",
+		})
+
+		return nil
+	}
+
+	return revision
+}
+
+// Reading old revisions would let burn-after-reading content be read
+// without burning it.
+func rejectBurnAfterReading(c *gin.Context, paste *models.Paste) bool {
+	if !paste.BurnAfterReading {
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"message": "Revisions of burn after reading pastes can't be read",
+	})
+
+	return true
+}
+
+func (h *Handler) readRevision(c *gin.Context, revision *models.PasteRevision) (
+	string, bool,
+) {
+	if revision.Size > maxRenderSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"message": "Revision is too large to diff",
+		})
+
+		return "", false
+	}
+
+	file, _, err := fileupload.GetFile(h.Storage, revision.BlobKey)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching file",
+		})
+
+		return "", false
+	}
+
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxRenderSize+1))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error reading file",
+		})
+
+		return "", false
+	}
+
+	if len(content) > maxRenderSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"message": "Revision is too large to diff",
+		})
+
+		return "", false
+	}
+
+	if !highlight.IsText(content) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"message": "Revision is not text and can't be diffed",
+		})
+
+		return "", false
+	}
+
+	return string(content), true
+}
+
+func (h *Handler) GetPasteRevisionsController(c *gin.Context) {
+	log.Println("Inside GetPasteRevisionsController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil {
+		return
+	}
+
+	revisions, err := h.Pastes.GetPasteRevisionsByPasteId(paste.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching revisions",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Revisions of paste with ID: " + paste.ID,
+		"data":    revisions,
+	})
+}
+
+func (h *Handler) GetPasteRevisionController(c *gin.Context) {
+	log.Println("Inside GetPasteRevisionController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil || rejectBurnAfterReading(c, paste) {
+		return
+	}
+
+	revision := h.getRevisionParam(c, paste, c.Param("revision"))
+	if revision == nil {
+		return
+	}
+
+	etag := `"` + revision.ContentHash + `"`
+	c.Header("ETag", etag)
+
+	if etagMatches(c.Request.Header.Get("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+
+		return
+	}
+
+	file, filesize, err := fileupload.GetFile(h.Storage, revision.BlobKey)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching file",
+		})
+
+		return
+	}
+
+	defer file.Close()
+
+	c.DataFromReader(
+		http.StatusOK, filesize, "application/octet-stream", file, nil,
+	)
+}
+
+func (h *Handler) GetPasteDiffController(c *gin.Context) {
+	log.Println("Inside GetPasteDiffController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil || rejectBurnAfterReading(c, paste) ||
+		rejectEncrypted(c, paste) {
+		return
+	}
+
+	to := h.getRevisionParam(
+		c, paste, c.DefaultQuery("to", strconv.Itoa(paste.Revision)),
+	)
+	if to == nil {
+		return
+	}
+
+	from := h.getRevisionParam(
+		c, paste, c.DefaultQuery("from", strconv.Itoa(to.Number-1)),
+	)
+	if from == nil {
+		return
+	}
+
+	fromContent, ok := h.readRevision(c, from)
+	if !ok {
+		return
+	}
+
+	toContent, ok := h.readRevision(c, to)
+	if !ok {
+		return
+	}
+
+	unified := diff.Unified(
+		fmt.Sprintf("%s@%d", paste.ID, from.Number),
+		fmt.Sprintf("%s@%d", paste.ID, to.Number),
+		fromContent, toContent, diffContextLines,
+	)
+
+	c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(unified))
+}
+
+func (h *Handler) RestorePasteRevisionController(c *gin.Context) {
+	log.Println("Inside RestorePasteRevisionController")
+
+	paste := h.getReadablePaste(c)
+	if paste == nil {
+		return
+	}
+
+	userId, _, ok := h.authorizePasteEdit(c, paste, "update", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	revision := h.getRevisionParam(c, paste, c.Param("revision"))
+	if revision == nil {
+		return
+	}
+
+	file, _, err := fileupload.GetFile(h.Storage, revision.BlobKey)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching file",
+		})
+
+		return
+	}
+
+	defer file.Close()
+
+	restored, err := h.uploadPasteRevision(
+		paste, userId, revision.Language, file,
+	)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error restoring revision",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": fmt.Sprintf(
+			"Restored revision %d as revision %d",
+			revision.Number, restored.Number,
+		),
+		"data": restored,
+	})
+}
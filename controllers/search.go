@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"bytes"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/highlight"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Only the start of a paste is indexed; a tsvector can't hold much more.
+const maxSearchContent = 256 << 10
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// Matches are marked with control characters that can't appear in indexed
+// content, so snippets can be HTML-escaped before the marks become <mark>
+// tags.
+const (
+	matchStart = "\x02"
+	matchStop  = "\x03"
+)
+
+// searchCapture keeps the start of an upload for indexing as it streams to
+// storage.
+type searchCapture struct {
+	bytes.Buffer
+}
+
+func (s *searchCapture) Write(p []byte) (int, error) {
+	remaining := maxSearchContent - s.Len()
+	if remaining > 0 {
+		if len(p) < remaining {
+			remaining = len(p)
+		}
+
+		s.Buffer.Write(p[:remaining])
+	}
+
+	return len(p), nil
+}
+
+// indexPaste indexes the title and captured content of a paste. Search is
+// best effort, so failures are only logged.
+func (h *Handler) indexPaste(paste *models.Paste, capture *searchCapture) {
+	content := ""
+
+	if !paste.Encrypted && highlight.IsText(capture.Bytes()) {
+		content = strings.ToValidUTF8(capture.String(), "")
+		content = strings.NewReplacer(
+			matchStart, "", matchStop, "",
+		).Replace(content)
+	}
+
+	err := h.Pastes.IndexPasteContent(paste.ID, paste.Title, content)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func markMatches(snippet string) string {
+	return strings.NewReplacer(
+		matchStart, "<mark>", matchStop, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// SearchPastesController searches the titles and content of the pastes the
+// caller can read. Snippets are HTML with matches wrapped in <mark>.
+func (h *Handler) SearchPastesController(c *gin.Context) {
+	log.Println("Inside SearchPastesController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide a search query",
+		})
+
+		return
+	}
+
+	limit, err := strconv.Atoi(
+		c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)),
+	)
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Limit must be between 1 and " +
+				strconv.Itoa(maxSearchLimit),
+		})
+
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid offset",
+		})
+
+		return
+	}
+
+	results, err := h.Pastes.SearchPastes(database.PasteSearchOptions{
+		UserID:     user.ID,
+		Query:      query,
+		MatchStart: matchStart,
+		MatchStop:  matchStop,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error searching pastes",
+		})
+
+		return
+	}
+
+	for i := range results {
+		results[i].TitleSnippet = markMatches(results[i].TitleSnippet)
+		results[i].Snippet = markMatches(results[i].Snippet)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Pastes matching: " + query,
+		"data":    results,
+	})
+}
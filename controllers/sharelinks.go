@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+type ShareLinkPayload struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	MaxViews  int        `json:"max_views"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// shareLinkRole returns the role granted by the share link the request was
+// made with, or "" if there is none. The middleware has already checked
+// that the link belongs to the requested paste.
+func shareLinkRole(c *gin.Context) string {
+	value, found := c.Get("shareLink")
+	if !found {
+		return ""
+	}
+
+	return value.(*models.ShareLink).Role()
+}
+
+func (h *Handler) CreateShareLinkController(c *gin.Context) {
+	log.Println("Inside CreateShareLinkController")
+
+	paste, user := h.getManagedPaste(c)
+	if paste == nil {
+		return
+	}
+
+	var payload ShareLinkPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid share link details",
+		})
+
+		return
+	}
+
+	if payload.Scope == "" {
+		payload.Scope = models.ShareLinkRead
+	}
+
+	if payload.Scope != models.ShareLinkRead &&
+		payload.Scope != models.ShareLinkWrite {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Scope must be read or write",
+		})
+
+		return
+	}
+
+	if payload.MaxViews < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "max_views can't be negative",
+		})
+
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "expires_at must be in the future",
+		})
+
+		return
+	}
+
+	link := models.ShareLink{
+		ID:        uuid.New(),
+		PasteID:   paste.ID,
+		CreatedBy: user.ID,
+		Name:      payload.Name,
+		Scope:     payload.Scope,
+		MaxViews:  payload.MaxViews,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	err = h.Access.CreateShareLinkRecord(&link)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating share link",
+		})
+
+		return
+	}
+
+	// Like API tokens, the token is only ever shown here.
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created share link with ID: " + link.ID.String(),
+		"data":    link,
+		"token":   h.ShareLinks.Sign(link.ID),
+	})
+}
+
+func (h *Handler) GetShareLinksController(c *gin.Context) {
+	log.Println("Inside GetShareLinksController")
+
+	paste, _ := h.getManagedPaste(c)
+	if paste == nil {
+		return
+	}
+
+	links, err := h.Access.GetShareLinksByPasteId(paste.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching share links",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Share links of paste with ID: " + paste.ID,
+		"data":    links,
+	})
+}
+
+func (h *Handler) RevokeShareLinkController(c *gin.Context) {
+	log.Println("Inside RevokeShareLinkController")
+
+	paste, _ := h.getManagedPaste(c)
+	if paste == nil {
+		return
+	}
+
+	linkId, err := uuid.Parse(c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid share link ID",
+		})
+
+		return
+	}
+
+	err = h.Access.RevokeShareLink(paste.ID, linkId, time.Now())
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Share link not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error revoking share link",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Share link revoked successfully!",
+	})
+}
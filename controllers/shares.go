@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// parseGrantRole reads the role query parameter, falling back to fallback
+// when it's missing. If the role can't be granted, it writes the error
+// response and returns false.
+func parseGrantRole(c *gin.Context, fallback string) (string, bool) {
+	role := c.DefaultQuery("role", fallback)
+	if !models.ValidGrantRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Role must be viewer, editor or co-owner",
+		})
+
+		return "", false
+	}
+
+	return role, true
+}
+
+// rejectAccessChange stops callers below co-owner from changing who can read
+// a paste, writing the error response and returning true.
+func rejectAccessChange(c *gin.Context, role string) bool {
+	if models.RoleAtLeast(role, models.RoleCoOwner) {
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"message": "Only co-owners can change who can read this paste",
+	})
+
+	return true
+}
+
+// getManagedPaste fetches the paste named by the :id parameter along with
+// the caller, who must be able to manage its shares. If not, it writes the
+// error response and returns nil.
+func (h *Handler) getManagedPaste(c *gin.Context) (*models.Paste, *models.User) {
+	paste := h.getReadablePaste(c)
+	if paste == nil {
+		return nil, nil
+	}
+
+	email, found := c.Get("email")
+	if !found {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "You are not authorized to manage the shares of this paste",
+		})
+
+		return nil, nil
+	}
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return nil, nil
+	}
+
+	allowed, err := h.hasPasteRole(user.ID, paste, models.RoleCoOwner)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return nil, nil
+	}
+
+	if !allowed {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "You are not authorized to manage the shares of this paste",
+		})
+
+		return nil, nil
+	}
+
+	return paste, user
+}
+
+func (h *Handler) GetPasteAccessesController(c *gin.Context) {
+	log.Println("Inside GetPasteAccessesController")
+
+	paste, _ := h.getManagedPaste(c)
+	if paste == nil {
+		return
+	}
+
+	users, err := h.Access.GetPasteAccessRecordsByPasteId(paste.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	teams, err := h.Access.GetTeamPasteAccessRecordsByPasteId(paste.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Shares of paste with ID: " + paste.ID,
+		"data": gin.H{
+			"users": users,
+			"teams": teams,
+		},
+	})
+}
+
+func (h *Handler) UpdatePasteAccessController(c *gin.Context) {
+	log.Println("Inside UpdatePasteAccessController")
+
+	email, _ := c.Get("email")
+
+	owner, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	pasteId, found := c.GetQuery("paste_id")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide the ID of the paste",
+		})
+
+		return
+	}
+
+	paste, err := h.Pastes.GetPasteByID(pasteId)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Paste not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste",
+		})
+
+		return
+	}
+
+	allowed, err := h.hasPasteRole(owner.ID, paste, models.RoleCoOwner)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	if !allowed {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "You are not authorized to share this paste",
+		})
+
+		return
+	}
+
+	role, ok := parseGrantRole(c, "")
+	if !ok {
+		return
+	}
+
+	teamId, found := c.GetQuery("team_id")
+	if found {
+		team := h.getShareableTeam(c, owner.ID, teamId)
+		if team == nil {
+			return
+		}
+
+		err = h.Access.UpdateTeamPasteAccessRole(team.ID, paste.ID, role)
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Paste is not shared with this team",
+			})
+
+			return
+		} else if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error updating paste access",
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Paste access updated successfully!",
+		})
+
+		return
+	}
+
+	userEmail, found := c.GetQuery("user_email")
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide the email of the user",
+		})
+
+		return
+	}
+
+	user, err := h.Users.GetUserByEmail(userEmail)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	pasteAccess, err := h.Access.GetPasteAccessRecordByUserIdAndPasteId(
+		user.ID, paste.ID,
+	)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Paste is not shared with this user",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching paste access",
+		})
+
+		return
+	}
+
+	if pasteAccess.AccessRole() == models.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "The owner's access can't be changed",
+		})
+
+		return
+	}
+
+	err = h.Access.UpdatePasteAccessRole(pasteAccess, role)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating paste access",
+		})
+
+		return
+	}
+
+	pasteAccess.Role = role
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Paste access updated successfully!",
+		"data":    pasteAccess,
+	})
+}
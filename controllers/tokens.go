@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Enough of the token to tell tokens apart in a listing without making the
+// stored value useful.
+const apiTokenPrefixLen = len(auth.APITokenPrefix) + 6
+
+type APITokenPayload struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (h *Handler) CreateAPITokenController(c *gin.Context) {
+	log.Println("Inside CreateAPITokenController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	var payload APITokenPayload
+
+	err = c.ShouldBindJSON(&payload)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid token details",
+		})
+
+		return
+	}
+
+	if len(payload.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Please provide at least one scope",
+		})
+
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !auth.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Unknown scope: " + scope,
+			})
+
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "expires_at must be in the future",
+		})
+
+		return
+	}
+
+	plaintext, err := auth.GenerateAPIToken()
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error generating token",
+		})
+
+		return
+	}
+
+	token := models.APIToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Name:      payload.Name,
+		Prefix:    plaintext[:apiTokenPrefixLen],
+		TokenHash: auth.HashToken(plaintext),
+		Scopes:    strings.Join(payload.Scopes, " "),
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	err = h.Users.CreateAPITokenRecord(&token)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error creating token",
+		})
+
+		return
+	}
+
+	// The plaintext token is only ever shown here.
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created API token with ID: " + token.ID.String(),
+		"data":    token,
+		"token":   plaintext,
+	})
+}
+
+func (h *Handler) GetAPITokensController(c *gin.Context) {
+	log.Println("Inside GetAPITokensController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	tokens, err := h.Users.GetAPITokensByUserId(user.ID)
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching tokens",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API tokens of user with ID: " + user.ID.String(),
+		"data":    tokens,
+	})
+}
+
+func (h *Handler) DeleteAPITokenController(c *gin.Context) {
+	log.Println("Inside DeleteAPITokenController")
+
+	email, _ := c.Get("email")
+
+	user, err := h.Users.GetUserByEmail(email.(string))
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error fetching user",
+		})
+
+		return
+	}
+
+	tokenId, err := uuid.Parse(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid token ID",
+		})
+
+		return
+	}
+
+	err = h.Users.DeleteAPITokenRecord(user.ID, tokenId)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Token not found",
+		})
+
+		return
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting token",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Deleted API token with ID: " + tokenId.String(),
+	})
+}
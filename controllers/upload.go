@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxFormValueSize = 1 << 20
+
+var errNoFilePart = errors.New("no file part in request")
+
+// openFilePart walks a multipart request up to its "file" part without
+// buffering it, collecting the plain form fields sent before it. Fields sent
+// after the file are ignored.
+func openFilePart(c *gin.Context) (*multipart.Part, map[string][]string, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form := map[string][]string{}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errNoFilePart
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		name := part.FormName()
+
+		if name == "file" {
+			return part, form, nil
+		}
+
+		if part.FileName() != "" {
+			part.Close()
+
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize))
+		part.Close()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		form[name] = append(form[name], string(value))
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// scheduleBlobDeletions records blob deletions, moving the time of any
+// already recorded to notBefore.
+func scheduleBlobDeletions(tx *gorm.DB, keys []string, notBefore time.Time) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	deletions := make([]models.BlobDeletion, len(keys))
+	for i, key := range keys {
+		deletions[i] = models.BlobDeletion{Key: key, NotBefore: notBefore}
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"not_before"}),
+	}).Create(&deletions).Error
+}
+
+func (s *SQL) ScheduleBlobDeletions(keys []string, notBefore time.Time) error {
+	return scheduleBlobDeletions(s.db, keys, notBefore)
+}
+
+func (s *SQL) GetDueBlobDeletions(now time.Time, limit int) (
+	[]models.BlobDeletion, error,
+) {
+	deletions := []models.BlobDeletion{}
+
+	result := s.db.Where(
+		"not_before <= ?", now,
+	).Order("not_before").Limit(limit).Find(&deletions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return deletions, nil
+}
+
+func (s *SQL) CompleteBlobDeletion(key string) error {
+	result := s.db.Where("key = ?", key).Delete(&models.BlobDeletion{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) RetryBlobDeletion(key string, lastError string, retryAt time.Time) error {
+	result := s.db.Model(&models.BlobDeletion{}).Where(
+		"key = ?", key,
+	).Updates(map[string]interface{}{
+		"not_before": retryAt,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": lastError,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetBlobDeletions() ([]models.BlobDeletion, error) {
+	deletions := []models.BlobDeletion{}
+
+	result := s.db.Order("not_before").Find(&deletions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return deletions, nil
+}
@@ -1,11 +1,13 @@
 package database
 
 import (
+	"errors"
 	"log"
-	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
@@ -13,33 +15,61 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var DB *gorm.DB
+// SQL is the store backed by a SQL database, either Postgres or SQLite.
+type SQL struct {
+	db     *gorm.DB
+	sqlite bool
+}
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env")
-	}
+// ConnectSQL connects to the database at url without migrating it. URLs
+// starting with sqlite: open a SQLite database file, and anything else is
+// passed to Postgres.
+func ConnectSQL(url string) (*SQL, error) {
+	store := &SQL{sqlite: strings.HasPrefix(url, sqliteScheme)}
 
-	dsn := os.Getenv("DATABASE_URL")
+	dialector := postgres.Open(url)
+	if store.sqlite {
+		dialector = openSQLite(url)
+	}
 
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(dialector, &gorm.Config{
+		TranslateError: true,
+	})
 	if err != nil {
-		log.Fatal("Failed to connect database", err)
+		return nil, err
 	}
 
 	log.Println("Connected to DB successfully!")
 
-	err = DB.AutoMigrate(&models.User{}, &models.Paste{}, &models.PasteAccess{})
+	store.db = db
+
+	return store, nil
+}
+
+// OpenSQL connects to the database at url and applies any pending
+// migrations. It refuses databases migrated by a newer build.
+func OpenSQL(url string) (*SQL, error) {
+	store, err := ConnectSQL(url)
 	if err != nil {
-		log.Fatal("Failed to migrate models", err)
+		return nil, err
+	}
+
+	applied, err := store.MigrateUp()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
 	}
 
 	log.Println("Migrated models successfully!")
+
+	return store, nil
 }
 
-func CreateUserRecord(user *models.User) error {
-	result := DB.Create(&user)
+func (s *SQL) CreateUserRecord(user *models.User) error {
+	result := s.db.Create(&user)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -67,10 +97,68 @@ func CheckPassword(providedPassword string, user *models.User) error {
 	return nil
 }
 
-func GetUserByEmail(email string) (*models.User, error) {
+func CheckPastePassword(providedPassword string, paste *models.Paste) error {
+	err := bcrypt.CompareHashAndPassword(
+		[]byte(paste.PasswordHash), []byte(providedPassword),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQL) GetUserByEmail(email string) (*models.User, error) {
+	user := &models.User{}
+
+	result := s.db.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return user, nil
+}
+
+func (s *SQL) RevokeToken(jti string, expiresAt time.Time) error {
+	result := s.db.Create(&models.RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+
+	result := s.db.Model(&models.RevokedToken{}).Where(
+		"jti = ?", jti,
+	).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+func (s *SQL) DeleteExpiredRevokedTokens(now time.Time) error {
+	result := s.db.Where(
+		"expires_at <= ?", now,
+	).Delete(&models.RevokedToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetUserByID(id uuid.UUID) (*models.User, error) {
 	user := &models.User{}
 
-	result := DB.Where("email = ?", email).First(&user)
+	result := s.db.Where("id = ?", id).First(&user)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -78,8 +166,8 @@ func GetUserByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-func CreatePasteRecord(paste *models.Paste) error {
-	result := DB.Create(&paste)
+func (s *SQL) CreateAPITokenRecord(token *models.APIToken) error {
+	result := s.db.Create(&token)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -87,10 +175,82 @@ func CreatePasteRecord(paste *models.Paste) error {
 	return nil
 }
 
-func GetPasteByID(id string) (*models.Paste, error) {
+func (s *SQL) GetAPITokensByUserId(userId uuid.UUID) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+
+	result := s.db.Where(
+		"user_id = ?", userId,
+	).Order("created_at").Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tokens, nil
+}
+
+func (s *SQL) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+
+	result := s.db.Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+func (s *SQL) TouchAPIToken(id uuid.UUID, usedAt time.Time) error {
+	result := s.db.Model(&models.APIToken{}).Where(
+		"id = ?", id,
+	).Update("last_used_at", usedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) DeleteAPITokenRecord(userId uuid.UUID, id uuid.UUID) error {
+	result := s.db.Where(
+		"id = ? AND user_id = ?", id, userId,
+	).Delete(&models.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *SQL) CreatePaste(
+	paste *models.Paste, revision *models.PasteRevision, owner *models.PasteAccess,
+) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(paste).Error
+		if err != nil {
+			return err
+		}
+
+		err = commitPasteRevision(tx, revision)
+		if err != nil {
+			return err
+		}
+
+		if owner == nil {
+			return nil
+		}
+
+		return tx.Create(owner).Error
+	})
+}
+
+func (s *SQL) GetPasteByID(id string) (*models.Paste, error) {
 	var paste models.Paste
 
-	result := DB.Where("id = ?", id).First(&paste)
+	result := s.db.Where("id = ?", id).First(&paste)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -98,8 +258,8 @@ func GetPasteByID(id string) (*models.Paste, error) {
 	return &paste, nil
 }
 
-func UpdatePasteRecord(pasteId string, paste *models.Paste) error {
-	result := DB.Model(&paste).Where(
+func (s *SQL) UpdatePasteRecord(pasteId string, paste *models.Paste) error {
+	result := s.db.Model(&paste).Where(
 		"id = ?", pasteId,
 	).Updates(&paste)
 	if result.Error != nil {
@@ -109,8 +269,10 @@ func UpdatePasteRecord(pasteId string, paste *models.Paste) error {
 	return nil
 }
 
-func DeletePasteRecord(paste *models.Paste) error {
-	result := DB.Delete(&paste)
+func (s *SQL) UpdatePasteExpiry(pasteId string, expiresAt *time.Time) error {
+	result := s.db.Model(&models.Paste{}).Where(
+		"id = ?", pasteId,
+	).Update("expires_at", expiresAt)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -118,8 +280,10 @@ func DeletePasteRecord(paste *models.Paste) error {
 	return nil
 }
 
-func CreatePasteAccessRecord(pasteAccess *models.PasteAccess) error {
-	result := DB.Create(&pasteAccess)
+func (s *SQL) UpdatePastePassword(pasteId string, passwordHash string) error {
+	result := s.db.Model(&models.Paste{}).Where(
+		"id = ?", pasteId,
+	).Update("password_hash", passwordHash)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -127,12 +291,143 @@ func CreatePasteAccessRecord(pasteAccess *models.PasteAccess) error {
 	return nil
 }
 
-func GetPasteAccessRecordsByUserId(userId uuid.UUID) (
+func (s *SQL) UpdatePasteVisibility(
+	pasteId string, visibility models.Visibility,
+) error {
+	result := s.db.Model(&models.Paste{}).Where(
+		"id = ?", pasteId,
+	).Update("visibility", visibility)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetExpiredPastes(now time.Time, limit int) ([]models.Paste, error) {
+	pastes := []models.Paste{}
+
+	result := s.db.Where(
+		"expires_at IS NOT NULL AND expires_at <= ?", now,
+	).Order("expires_at").Limit(limit).Find(&pastes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return pastes, nil
+}
+
+// Deleting the paste row is the claim: only one transaction can delete it.
+func (s *SQL) ClaimBurnAfterReadingPaste(pasteId string, blobsNotBefore time.Time) (
+	bool, error,
+) {
+	claimed := false
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var paste models.Paste
+
+		result := tx.Where(
+			"id = ? AND burn_after_reading = ?", pasteId, true,
+		).First(&paste)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil
+		} else if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Where(
+			"id = ? AND burn_after_reading = ?", pasteId, true,
+		).Delete(&models.Paste{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		claimed = true
+
+		_, err := deletePasteRecords(tx, &paste, blobsNotBefore)
+
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return claimed, nil
+}
+
+func (s *SQL) DeletePaste(paste *models.Paste) ([]string, error) {
+	var keys []string
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(paste)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var err error
+
+		keys, err = deletePasteRecords(tx, paste, time.Now())
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// deletePasteRecords deletes everything recorded about a deleted paste, and
+// schedules its blobs for deletion once notBefore has passed. It returns
+// the keys of the blobs.
+func deletePasteRecords(tx *gorm.DB, paste *models.Paste, notBefore time.Time) (
+	[]string, error,
+) {
+	keys, err := pasteBlobKeys(tx, paste)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scheduleBlobDeletions(tx, keys, notBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, model := range []interface{}{
+		&models.PasteRevision{},
+		&models.PasteAccess{},
+		&models.TeamPasteAccess{},
+		&models.ShareLink{},
+		&models.PasteSearchDocument{},
+	} {
+		err = tx.Where("paste_id = ?", paste.ID).Delete(model).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *SQL) CreatePasteAccessRecord(pasteAccess *models.PasteAccess) error {
+	result := s.db.Create(&pasteAccess)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetPasteAccessRecordsByUserId(userId uuid.UUID) (
 	[]models.PasteAccess, error,
 ) {
 	pasteAccessRecords := []models.PasteAccess{}
 
-	result := DB.Model(&models.PasteAccess{}).Where(
+	result := s.db.Model(&models.PasteAccess{}).Where(
 		"user_id = ?", userId,
 	).Find(&pasteAccessRecords)
 	if result.Error != nil {
@@ -142,12 +437,12 @@ func GetPasteAccessRecordsByUserId(userId uuid.UUID) (
 	return pasteAccessRecords, nil
 }
 
-func GetPasteAccessRecordsByPasteId(pasteId string) (
+func (s *SQL) GetPasteAccessRecordsByPasteId(pasteId string) (
 	[]models.PasteAccess, error,
 ) {
 	pasteAccessRecords := []models.PasteAccess{}
 
-	result := DB.Model(&models.PasteAccess{}).Where(
+	result := s.db.Model(&models.PasteAccess{}).Where(
 		"paste_id = ?", pasteId,
 	).Find(&pasteAccessRecords)
 	if result.Error != nil {
@@ -157,14 +452,14 @@ func GetPasteAccessRecordsByPasteId(pasteId string) (
 	return pasteAccessRecords, nil
 }
 
-func GetPasteAccessRecordByUserIdAndPasteId(userId uuid.UUID, pasteId string) (
+func (s *SQL) GetPasteAccessRecordByUserIdAndPasteId(userId uuid.UUID, pasteId string) (
 	*models.PasteAccess, error,
 ) {
 	var pasteAccess models.PasteAccess
 
-	result := DB.Model(&models.PasteAccess{}).Where(
+	result := s.db.Model(&models.PasteAccess{}).Where(
 		"paste_id = ? AND user_id = ?", pasteId, userId,
-	).First(&models.PasteAccess{})
+	).First(&pasteAccess)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -172,11 +467,216 @@ func GetPasteAccessRecordByUserIdAndPasteId(userId uuid.UUID, pasteId string) (
 	return &pasteAccess, nil
 }
 
-func DeletePasteAccessRecord(pasteAccess *models.PasteAccess) error {
-	result := DB.Delete(&pasteAccess)
+func (s *SQL) UpdatePasteAccessRole(pasteAccess *models.PasteAccess, role string) error {
+	result := s.db.Model(pasteAccess).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) DeletePasteAccessRecord(pasteAccess *models.PasteAccess) error {
+	result := s.db.Delete(&pasteAccess)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetDanglingPasteAccessRecords() ([]models.PasteAccess, error) {
+	pasteAccessRecords := []models.PasteAccess{}
+
+	result := s.db.Where(
+		"paste_id NOT IN (SELECT id FROM pastes)",
+	).Or(
+		"user_id NOT IN (SELECT id FROM users)",
+	).Find(&pasteAccessRecords)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return pasteAccessRecords, nil
+}
+
+const maxRevisionAttempts = 3
+
+// Concurrent commits that pick the same revision number are retried.
+func (s *SQL) CommitPasteRevision(revision *models.PasteRevision) error {
+	var err error
+
+	for attempt := 0; attempt < maxRevisionAttempts; attempt++ {
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			return commitPasteRevision(tx, revision)
+		})
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func commitPasteRevision(tx *gorm.DB, revision *models.PasteRevision) error {
+	var latest int
+
+	result := tx.Model(&models.PasteRevision{}).Where(
+		"paste_id = ?", revision.PasteID,
+	).Select("COALESCE(MAX(number), 0)").Scan(&latest)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if latest == 0 {
+		var paste models.Paste
+
+		result = tx.Where("id = ?", revision.PasteID).First(&paste)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if paste.BlobKey == "" {
+			original := models.PasteRevision{
+				ID:          uuid.New(),
+				PasteID:     paste.ID,
+				Number:      1,
+				UserID:      paste.UserID,
+				Size:        paste.Size,
+				ContentHash: paste.ContentHash,
+				Language:    paste.Language,
+				BlobKey:     paste.StorageKey(),
+				CreatedAt:   paste.UpdatedAt,
+			}
+
+			result = tx.Create(&original)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			latest = 1
+		}
+	}
+
+	revision.Number = latest + 1
+
+	result = tx.Create(revision)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	result = tx.Where(
+		"key = ?", revision.BlobKey,
+	).Delete(&models.BlobDeletion{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	result = tx.Model(&models.Paste{}).Where(
+		"id = ?", revision.PasteID,
+	).Updates(map[string]interface{}{
+		"revision":     revision.Number,
+		"blob_key":     revision.BlobKey,
+		"size":         revision.Size,
+		"content_hash": revision.ContentHash,
+		"language":     revision.Language,
+	})
+
+	return result.Error
+}
+
+func (s *SQL) GetPasteRevisionsByPasteId(pasteId string) (
+	[]models.PasteRevision, error,
+) {
+	revisions := []models.PasteRevision{}
+
+	result := s.db.Where(
+		"paste_id = ?", pasteId,
+	).Order("number").Find(&revisions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return revisions, nil
+}
+
+func (s *SQL) GetPasteRevision(pasteId string, number int) (
+	*models.PasteRevision, error,
+) {
+	var revision models.PasteRevision
+
+	result := s.db.Where(
+		"paste_id = ? AND number = ?", pasteId, number,
+	).First(&revision)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &revision, nil
+}
+
+func (s *SQL) GetPasteBlobKeys(paste *models.Paste) ([]string, error) {
+	return pasteBlobKeys(s.db, paste)
+}
+
+func (s *SQL) DeletePasteRevisionRecord(revision *models.PasteRevision) error {
+	result := s.db.Delete(revision)
 	if result.Error != nil {
 		return result.Error
 	}
 
 	return nil
 }
+
+func (s *SQL) WalkPastes(batchSize int, fn func(paste *models.Paste) error) error {
+	pastes := []models.Paste{}
+
+	result := s.db.FindInBatches(&pastes, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range pastes {
+			err := fn(&pastes[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return result.Error
+}
+
+func (s *SQL) WalkPasteRevisions(
+	batchSize int, fn func(revision *models.PasteRevision) error,
+) error {
+	revisions := []models.PasteRevision{}
+
+	result := s.db.FindInBatches(&revisions, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range revisions {
+			err := fn(&revisions[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return result.Error
+}
+
+func pasteBlobKeys(tx *gorm.DB, paste *models.Paste) ([]string, error) {
+	var keys []string
+
+	result := tx.Model(&models.PasteRevision{}).Where(
+		"paste_id = ?", paste.ID,
+	).Distinct().Pluck("blob_key", &keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if !slices.Contains(keys, paste.StorageKey()) {
+		keys = append(keys, paste.StorageKey())
+	}
+
+	return keys, nil
+}
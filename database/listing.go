@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Columns pastes can be listed by. Every sort is broken by ID, so the order
+// is total and cursors never skip or repeat a paste.
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+	SortTitle     = "title"
+)
+
+func ValidPasteSort(sort string) bool {
+	return sort == SortCreatedAt || sort == SortUpdatedAt || sort == SortTitle
+}
+
+// PasteCursor is the position of the last paste of a page: its value of the
+// sort column and its ID.
+type PasteCursor struct {
+	Value interface{}
+	ID    string
+}
+
+type PasteListOptions struct {
+	UserID uuid.UUID
+	// Only pastes the user owns when true, only pastes shared with them when
+	// false, and both when nil.
+	Owned         *bool
+	Visibility    *models.Visibility
+	Language      string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	TitlePrefix   string
+	Sort          string
+	Descending    bool
+	After         *PasteCursor
+	Limit         int
+}
+
+const sharedPasteCondition = `(pastes.user_id <> ? AND
+	pastes.visibility NOT IN ? AND (
+		EXISTS (
+			SELECT 1 FROM paste_accesses
+			WHERE paste_accesses.paste_id = pastes.id
+			AND paste_accesses.user_id = ?
+		) OR EXISTS (
+			SELECT 1 FROM team_paste_accesses
+			JOIN team_memberships
+			ON team_memberships.team_id = team_paste_accesses.team_id
+			WHERE team_paste_accesses.paste_id = pastes.id
+			AND team_memberships.user_id = ?
+		) OR pastes.organization_id IN (
+			SELECT organization_id FROM organization_memberships
+			WHERE organization_memberships.user_id = ?
+			AND (organization_memberships.role = ? OR pastes.visibility = ?)
+		)
+	))`
+
+// sharedPastes matches the pastes other users have shared with a user,
+// directly, through a team or through an organization, that would appear in
+// their listings. Organization admins see every listed paste of the
+// organization, and members see its org-only pastes.
+func (s *SQL) sharedPastes(userId uuid.UUID) *gorm.DB {
+	unlisted := []int{int(models.Unlisted), int(models.SharedWithLink)}
+
+	return s.db.Where(
+		sharedPasteCondition, userId, unlisted, userId, userId,
+		userId, models.OrganizationAdmin, int(models.OrgOnly),
+	)
+}
+
+// ListPastes lists a page in one query, whatever the user has access to.
+func (s *SQL) ListPastes(opts PasteListOptions) ([]models.Paste, error) {
+	pastes := []models.Paste{}
+
+	shared := s.sharedPastes(opts.UserID)
+
+	query := s.db.Model(&models.Paste{}).Where(
+		"pastes.expires_at IS NULL OR pastes.expires_at > ?", time.Now(),
+	)
+
+	switch {
+	case opts.Owned == nil:
+		query = query.Where(
+			s.db.Where("pastes.user_id = ?", opts.UserID).Or(shared),
+		)
+	case *opts.Owned:
+		query = query.Where("pastes.user_id = ?", opts.UserID)
+	default:
+		query = query.Where(shared)
+	}
+
+	if opts.Visibility != nil {
+		query = query.Where("pastes.visibility = ?", int(*opts.Visibility))
+	}
+
+	if opts.Language != "" {
+		query = query.Where("pastes.language = ?", opts.Language)
+	}
+
+	if opts.CreatedAfter != nil {
+		query = query.Where("pastes.created_at >= ?", *opts.CreatedAfter)
+	}
+
+	if opts.CreatedBefore != nil {
+		query = query.Where("pastes.created_at < ?", *opts.CreatedBefore)
+	}
+
+	if opts.TitlePrefix != "" {
+		query = query.Where(
+			`LOWER(pastes.title) LIKE ? ESCAPE '\'`,
+			strings.ToLower(escapeLike(opts.TitlePrefix))+"%",
+		)
+	}
+
+	column := "pastes." + opts.Sort
+	direction, comparison := "ASC", ">"
+
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if opts.After != nil {
+		query = query.Where(
+			fmt.Sprintf(
+				"%s %s ? OR (%s = ? AND pastes.id %s ?)",
+				column, comparison, column, comparison,
+			),
+			opts.After.Value, opts.After.Value, opts.After.ID,
+		)
+	}
+
+	result := query.Order(
+		column + " " + direction + ", pastes.id " + direction,
+	).Limit(opts.Limit).Find(&pastes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return pastes, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`, "%", `\%`, "_", `\_`,
+	).Replace(value)
+}
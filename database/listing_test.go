@@ -0,0 +1,99 @@
+package database
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func TestListPastesIncludesOrganizationPastes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		admin := createTestUser(t, store, "admin@example.com")
+		creator := createTestUser(t, store, "creator@example.com")
+		member := createTestUser(t, store, "member@example.com")
+		outsider := createTestUser(t, store, "outsider@example.com")
+
+		organization := &models.Organization{ID: uuid.New(), Name: "acme"}
+
+		err := store.Access.CreateOrganizationRecord(organization, admin.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, user := range []*models.User{creator, member} {
+			err = store.Access.CreateOrganizationMembershipRecord(
+				&models.OrganizationMembership{
+					ID:             uuid.New(),
+					OrganizationID: organization.ID,
+					UserID:         user.ID,
+					Role:           models.OrganizationMember,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		pastes := map[models.Visibility]string{}
+
+		for _, visibility := range []models.Visibility{
+			models.Private, models.OrgOnly, models.Unlisted,
+		} {
+			paste := createTestPaste(t, store, creator, models.Paste{
+				Visibility:     visibility,
+				OrganizationID: &organization.ID,
+			})
+			pastes[visibility] = paste.ID
+		}
+
+		shared := false
+
+		for _, test := range []struct {
+			name  string
+			user  *models.User
+			owned *bool
+			want  []string
+		}{
+			{"admin", admin, nil, []string{pastes[models.Private], pastes[models.OrgOnly]}},
+			{"admin shared", admin, &shared, []string{pastes[models.Private], pastes[models.OrgOnly]}},
+			{"member", member, nil, []string{pastes[models.OrgOnly]}},
+			{"outsider", outsider, nil, nil},
+		} {
+			listed, err := store.Pastes.ListPastes(PasteListOptions{
+				UserID: test.user.ID,
+				Owned:  test.owned,
+				Sort:   SortCreatedAt,
+				Limit:  10,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, paste := range listed {
+				got = append(got, paste.ID)
+			}
+
+			want := append([]string{}, test.want...)
+			sort.Strings(got)
+			sort.Strings(want)
+
+			if len(got) != len(want) {
+				t.Errorf("%s: listed %v, want %v", test.name, got, want)
+
+				continue
+			}
+
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("%s: listed %v, want %v", test.name, got, want)
+
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,573 @@
+package database
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+type searchDocument struct {
+	title   string
+	content string
+}
+
+// Memory is a store that keeps everything in process, for tests and for
+// running the server without a database. It keeps the same constraints as
+// the SQL store, and searches by matching words rather than with a full-text
+// index.
+type Memory struct {
+	mu sync.Mutex
+
+	users         map[uuid.UUID]models.User
+	revokedTokens map[string]models.RevokedToken
+	apiTokens     map[uuid.UUID]models.APIToken
+
+	pastes          map[string]models.Paste
+	revisions       map[string][]models.PasteRevision
+	searchDocuments map[string]searchDocument
+	blobDeletions   map[string]models.BlobDeletion
+
+	pasteAccesses     map[uuid.UUID]models.PasteAccess
+	organizations     map[uuid.UUID]models.Organization
+	orgMemberships    map[uuid.UUID]models.OrganizationMembership
+	teams             map[uuid.UUID]models.Team
+	teamMemberships   map[uuid.UUID]models.TeamMembership
+	teamPasteAccesses map[uuid.UUID]models.TeamPasteAccess
+	shareLinks        map[uuid.UUID]models.ShareLink
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:             map[uuid.UUID]models.User{},
+		revokedTokens:     map[string]models.RevokedToken{},
+		apiTokens:         map[uuid.UUID]models.APIToken{},
+		pastes:            map[string]models.Paste{},
+		revisions:         map[string][]models.PasteRevision{},
+		searchDocuments:   map[string]searchDocument{},
+		blobDeletions:     map[string]models.BlobDeletion{},
+		pasteAccesses:     map[uuid.UUID]models.PasteAccess{},
+		organizations:     map[uuid.UUID]models.Organization{},
+		orgMemberships:    map[uuid.UUID]models.OrganizationMembership{},
+		teams:             map[uuid.UUID]models.Team{},
+		teamMemberships:   map[uuid.UUID]models.TeamMembership{},
+		teamPasteAccesses: map[uuid.UUID]models.TeamPasteAccess{},
+		shareLinks:        map[uuid.UUID]models.ShareLink{},
+	}
+}
+
+// setTimestamps fills in CreatedAt and UpdatedAt the way GORM does on create.
+func setTimestamps(record interface{}) {
+	value := reflect.ValueOf(record).Elem()
+	now := reflect.ValueOf(time.Now())
+
+	for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+		field := value.FieldByName(name)
+		if field.IsValid() && field.IsZero() {
+			field.Set(now)
+		}
+	}
+}
+
+// updateNonZero copies the non-zero fields of src to dst, like GORM's
+// Updates with a struct.
+func updateNonZero(dst interface{}, src interface{}) {
+	to := reflect.ValueOf(dst).Elem()
+	from := reflect.ValueOf(src).Elem()
+
+	for i := 0; i < from.NumField(); i++ {
+		if !from.Field(i).IsZero() {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+
+	updatedAt := to.FieldByName("UpdatedAt")
+	if updatedAt.IsValid() {
+		updatedAt.Set(reflect.ValueOf(time.Now()))
+	}
+}
+
+func (m *Memory) CreateUserRecord(user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.ID == user.ID || existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(user)
+	m.users[user.ID] = *user
+
+	return nil
+}
+
+func (m *Memory) GetUserByEmail(email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetUserByID(id uuid.UUID) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, found := m.users[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return &user, nil
+}
+
+func (m *Memory) RevokeToken(jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.revokedTokens[jti]; found {
+		return ErrDuplicate
+	}
+
+	m.revokedTokens[jti] = models.RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (m *Memory) IsTokenRevoked(jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, found := m.revokedTokens[jti]
+
+	return found, nil
+}
+
+func (m *Memory) DeleteExpiredRevokedTokens(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for jti, token := range m.revokedTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(m.revokedTokens, jti)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) CreateAPITokenRecord(token *models.APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.apiTokens {
+		if existing.ID == token.ID || existing.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(token)
+	m.apiTokens[token.ID] = *token
+
+	return nil
+}
+
+func (m *Memory) GetAPITokensByUserId(userId uuid.UUID) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := []models.APIToken{}
+
+	for _, token := range m.apiTokens {
+		if token.UserID == userId {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (m *Memory) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.apiTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) TouchAPIToken(id uuid.UUID, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, found := m.apiTokens[id]
+	if found {
+		token.LastUsedAt = &usedAt
+		m.apiTokens[id] = token
+	}
+
+	return nil
+}
+
+func (m *Memory) DeleteAPITokenRecord(userId uuid.UUID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, found := m.apiTokens[id]
+	if !found || token.UserID != userId {
+		return ErrNotFound
+	}
+
+	delete(m.apiTokens, id)
+
+	return nil
+}
+
+func (m *Memory) CreatePaste(
+	paste *models.Paste, revision *models.PasteRevision, owner *models.PasteAccess,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.pastes[paste.ID]; found {
+		return ErrDuplicate
+	}
+
+	if owner != nil {
+		if _, found := m.pasteAccesses[owner.ID]; found {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(paste)
+	m.pastes[paste.ID] = *paste
+
+	m.commitPasteRevision(revision)
+
+	if owner != nil {
+		setTimestamps(owner)
+		m.pasteAccesses[owner.ID] = *owner
+	}
+
+	return nil
+}
+
+func (m *Memory) GetPasteByID(id string) (*models.Paste, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, found := m.pastes[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return &paste, nil
+}
+
+func (m *Memory) UpdatePasteRecord(pasteId string, paste *models.Paste) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.pastes[pasteId]
+	if !found {
+		return nil
+	}
+
+	updateNonZero(&stored, paste)
+	m.pastes[pasteId] = stored
+
+	return nil
+}
+
+func (m *Memory) updatePaste(pasteId string, update func(paste *models.Paste)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, found := m.pastes[pasteId]
+	if !found {
+		return
+	}
+
+	update(&paste)
+	paste.UpdatedAt = time.Now()
+	m.pastes[pasteId] = paste
+}
+
+func (m *Memory) UpdatePasteExpiry(pasteId string, expiresAt *time.Time) error {
+	m.updatePaste(pasteId, func(paste *models.Paste) {
+		paste.ExpiresAt = expiresAt
+	})
+
+	return nil
+}
+
+func (m *Memory) UpdatePasteVisibility(
+	pasteId string, visibility models.Visibility,
+) error {
+	m.updatePaste(pasteId, func(paste *models.Paste) {
+		paste.Visibility = visibility
+	})
+
+	return nil
+}
+
+func (m *Memory) UpdatePastePassword(pasteId string, passwordHash string) error {
+	m.updatePaste(pasteId, func(paste *models.Paste) {
+		paste.PasswordHash = passwordHash
+	})
+
+	return nil
+}
+
+func (m *Memory) DeletePaste(paste *models.Paste) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deletePaste(paste, time.Now()), nil
+}
+
+// deletePaste deletes a paste and everything recorded about it, scheduling
+// its blobs for deletion once notBefore has passed, and returns the keys of
+// the blobs. The caller holds the lock.
+func (m *Memory) deletePaste(paste *models.Paste, notBefore time.Time) []string {
+	keys := m.pasteBlobKeys(paste)
+	m.scheduleBlobDeletions(keys, notBefore)
+
+	delete(m.pastes, paste.ID)
+	delete(m.revisions, paste.ID)
+	delete(m.searchDocuments, paste.ID)
+	m.deletePasteAccesses(paste.ID)
+
+	return keys
+}
+
+func (m *Memory) GetExpiredPastes(now time.Time, limit int) ([]models.Paste, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pastes := []models.Paste{}
+
+	for _, paste := range m.pastes {
+		if paste.ExpiresAt != nil && !paste.ExpiresAt.After(now) {
+			pastes = append(pastes, paste)
+		}
+	}
+
+	sort.Slice(pastes, func(i, j int) bool {
+		return pastes[i].ExpiresAt.Before(*pastes[j].ExpiresAt)
+	})
+
+	if len(pastes) > limit {
+		pastes = pastes[:limit]
+	}
+
+	return pastes, nil
+}
+
+func (m *Memory) ClaimBurnAfterReadingPaste(pasteId string, blobsNotBefore time.Time) (
+	bool, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, found := m.pastes[pasteId]
+	if !found || !paste.BurnAfterReading {
+		return false, nil
+	}
+
+	m.deletePaste(&paste, blobsNotBefore)
+
+	return true, nil
+}
+
+func (m *Memory) CommitPasteRevision(revision *models.PasteRevision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.pastes[revision.PasteID]; !found {
+		return ErrNotFound
+	}
+
+	m.commitPasteRevision(revision)
+
+	return nil
+}
+
+// commitPasteRevision commits a revision of a paste that exists. The caller
+// holds the lock.
+func (m *Memory) commitPasteRevision(revision *models.PasteRevision) {
+	paste := m.pastes[revision.PasteID]
+
+	revisions := m.revisions[revision.PasteID]
+
+	if len(revisions) == 0 && paste.BlobKey == "" {
+		revisions = append(revisions, models.PasteRevision{
+			ID:          uuid.New(),
+			PasteID:     paste.ID,
+			Number:      1,
+			UserID:      paste.UserID,
+			Size:        paste.Size,
+			ContentHash: paste.ContentHash,
+			Language:    paste.Language,
+			BlobKey:     paste.StorageKey(),
+			CreatedAt:   paste.UpdatedAt,
+		})
+	}
+
+	// Numbers follow the highest one, as revisions can be deleted.
+	latest := 0
+	for _, existing := range revisions {
+		latest = max(latest, existing.Number)
+	}
+
+	revision.Number = latest + 1
+	setTimestamps(revision)
+	m.revisions[revision.PasteID] = append(revisions, *revision)
+
+	paste.Revision = revision.Number
+	paste.BlobKey = revision.BlobKey
+	paste.Size = revision.Size
+	paste.ContentHash = revision.ContentHash
+	paste.Language = revision.Language
+	paste.UpdatedAt = time.Now()
+	m.pastes[paste.ID] = paste
+
+	delete(m.blobDeletions, revision.BlobKey)
+}
+
+func (m *Memory) GetPasteRevisionsByPasteId(pasteId string) (
+	[]models.PasteRevision, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.PasteRevision{}, m.revisions[pasteId]...), nil
+}
+
+func (m *Memory) GetPasteRevision(pasteId string, number int) (
+	*models.PasteRevision, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, revision := range m.revisions[pasteId] {
+		if revision.Number == number {
+			return &revision, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetPasteBlobKeys(paste *models.Paste) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pasteBlobKeys(paste), nil
+}
+
+func (m *Memory) DeletePasteRevisionRecord(revision *models.PasteRevision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revisions[revision.PasteID] = slices.DeleteFunc(
+		m.revisions[revision.PasteID], func(stored models.PasteRevision) bool {
+			return stored.ID == revision.ID
+		},
+	)
+
+	return nil
+}
+
+// WalkPastes works on a copy of the pastes, so fn can use the store.
+func (m *Memory) WalkPastes(batchSize int, fn func(paste *models.Paste) error) error {
+	m.mu.Lock()
+
+	pastes := make([]models.Paste, 0, len(m.pastes))
+	for _, paste := range m.pastes {
+		pastes = append(pastes, paste)
+	}
+
+	m.mu.Unlock()
+
+	sort.Slice(pastes, func(i, j int) bool {
+		return pastes[i].ID < pastes[j].ID
+	})
+
+	for i := range pastes {
+		err := fn(&pastes[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) WalkPasteRevisions(
+	batchSize int, fn func(revision *models.PasteRevision) error,
+) error {
+	m.mu.Lock()
+
+	revisions := []models.PasteRevision{}
+	for _, pasteRevisions := range m.revisions {
+		revisions = append(revisions, pasteRevisions...)
+	}
+
+	m.mu.Unlock()
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID.String() < revisions[j].ID.String()
+	})
+
+	for i := range revisions {
+		err := fn(&revisions[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pasteBlobKeys lists the storage keys of a paste. The caller holds the
+// lock.
+func (m *Memory) pasteBlobKeys(paste *models.Paste) []string {
+	var keys []string
+
+	for _, revision := range m.revisions[paste.ID] {
+		if !slices.Contains(keys, revision.BlobKey) {
+			keys = append(keys, revision.BlobKey)
+		}
+	}
+
+	if !slices.Contains(keys, paste.StorageKey()) {
+		keys = append(keys, paste.StorageKey())
+	}
+
+	return keys
+}
@@ -0,0 +1,607 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// deletePasteAccesses removes every grant and share link on a paste. The
+// caller holds the lock.
+func (m *Memory) deletePasteAccesses(pasteId string) {
+	for id, access := range m.pasteAccesses {
+		if access.PasteID == pasteId {
+			delete(m.pasteAccesses, id)
+		}
+	}
+
+	for id, access := range m.teamPasteAccesses {
+		if access.PasteID == pasteId {
+			delete(m.teamPasteAccesses, id)
+		}
+	}
+
+	for id, link := range m.shareLinks {
+		if link.PasteID == pasteId {
+			delete(m.shareLinks, id)
+		}
+	}
+}
+
+func (m *Memory) CreatePasteAccessRecord(pasteAccess *models.PasteAccess) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.pasteAccesses {
+		if existing.ID == pasteAccess.ID ||
+			(existing.PasteID == pasteAccess.PasteID && existing.UserID == pasteAccess.UserID) {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(pasteAccess)
+	m.pasteAccesses[pasteAccess.ID] = *pasteAccess
+
+	return nil
+}
+
+func (m *Memory) GetPasteAccessRecordsByPasteId(pasteId string) (
+	[]models.PasteAccess, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accesses := []models.PasteAccess{}
+
+	for _, access := range m.pasteAccesses {
+		if access.PasteID == pasteId {
+			accesses = append(accesses, access)
+		}
+	}
+
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].CreatedAt.Before(accesses[j].CreatedAt)
+	})
+
+	return accesses, nil
+}
+
+func (m *Memory) GetPasteAccessRecordByUserIdAndPasteId(userId uuid.UUID, pasteId string) (
+	*models.PasteAccess, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, access := range m.pasteAccesses {
+		if access.PasteID == pasteId && access.UserID == userId {
+			return &access, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) UpdatePasteAccessRole(pasteAccess *models.PasteAccess, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.pasteAccesses[pasteAccess.ID]
+	if found {
+		stored.Role = role
+		stored.UpdatedAt = time.Now()
+		m.pasteAccesses[pasteAccess.ID] = stored
+	}
+
+	pasteAccess.Role = role
+
+	return nil
+}
+
+func (m *Memory) DeletePasteAccessRecord(pasteAccess *models.PasteAccess) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pasteAccesses, pasteAccess.ID)
+
+	return nil
+}
+
+func (m *Memory) GetDanglingPasteAccessRecords() ([]models.PasteAccess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accesses := []models.PasteAccess{}
+
+	for _, access := range m.pasteAccesses {
+		_, pasteFound := m.pastes[access.PasteID]
+		_, userFound := m.users[access.UserID]
+
+		if !pasteFound || !userFound {
+			accesses = append(accesses, access)
+		}
+	}
+
+	return accesses, nil
+}
+
+func (m *Memory) CreateOrganizationRecord(
+	organization *models.Organization, adminId uuid.UUID,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.organizations {
+		if existing.ID == organization.ID || existing.Name == organization.Name {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(organization)
+	m.organizations[organization.ID] = *organization
+
+	membership := models.OrganizationMembership{
+		ID:             uuid.New(),
+		OrganizationID: organization.ID,
+		UserID:         adminId,
+		Role:           models.OrganizationAdmin,
+	}
+	setTimestamps(&membership)
+	m.orgMemberships[membership.ID] = membership
+
+	return nil
+}
+
+func (m *Memory) GetOrganizationByID(id uuid.UUID) (*models.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	organization, found := m.organizations[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return &organization, nil
+}
+
+func (m *Memory) GetOrganizationsByUserId(userId uuid.UUID) ([]models.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	organizations := []models.Organization{}
+
+	for _, membership := range m.orgMemberships {
+		if membership.UserID == userId {
+			organizations = append(
+				organizations, m.organizations[membership.OrganizationID],
+			)
+		}
+	}
+
+	sort.Slice(organizations, func(i, j int) bool {
+		return organizations[i].Name < organizations[j].Name
+	})
+
+	return organizations, nil
+}
+
+func (m *Memory) CreateOrganizationMembershipRecord(
+	membership *models.OrganizationMembership,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.orgMemberships {
+		if existing.ID == membership.ID ||
+			(existing.OrganizationID == membership.OrganizationID &&
+				existing.UserID == membership.UserID) {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(membership)
+	m.orgMemberships[membership.ID] = *membership
+
+	return nil
+}
+
+func (m *Memory) GetOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) (
+	*models.OrganizationMembership, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, membership := range m.orgMemberships {
+		if membership.OrganizationID == organizationId && membership.UserID == userId {
+			return &membership, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetOrganizationMemberships(organizationId uuid.UUID) (
+	[]models.OrganizationMembership, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	memberships := []models.OrganizationMembership{}
+
+	for _, membership := range m.orgMemberships {
+		if membership.OrganizationID == organizationId {
+			memberships = append(memberships, membership)
+		}
+	}
+
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].CreatedAt.Before(memberships[j].CreatedAt)
+	})
+
+	return memberships, nil
+}
+
+func (m *Memory) DeleteOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := false
+
+	for id, membership := range m.orgMemberships {
+		if membership.OrganizationID == organizationId && membership.UserID == userId {
+			delete(m.orgMemberships, id)
+			deleted = true
+		}
+	}
+
+	if !deleted {
+		return ErrNotFound
+	}
+
+	for id, membership := range m.teamMemberships {
+		if membership.UserID == userId &&
+			m.teams[membership.TeamID].OrganizationID == organizationId {
+			delete(m.teamMemberships, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) CreateTeamRecord(team *models.Team) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.teams {
+		if existing.ID == team.ID ||
+			(existing.OrganizationID == team.OrganizationID &&
+				existing.Name == team.Name) {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(team)
+	m.teams[team.ID] = *team
+
+	return nil
+}
+
+func (m *Memory) GetTeamByID(id uuid.UUID) (*models.Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	team, found := m.teams[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return &team, nil
+}
+
+func (m *Memory) GetTeamsByOrganizationId(organizationId uuid.UUID) ([]models.Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	teams := []models.Team{}
+
+	for _, team := range m.teams {
+		if team.OrganizationID == organizationId {
+			teams = append(teams, team)
+		}
+	}
+
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Name < teams[j].Name
+	})
+
+	return teams, nil
+}
+
+func (m *Memory) DeleteTeamRecord(team *models.Team) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, membership := range m.teamMemberships {
+		if membership.TeamID == team.ID {
+			delete(m.teamMemberships, id)
+		}
+	}
+
+	for id, access := range m.teamPasteAccesses {
+		if access.TeamID == team.ID {
+			delete(m.teamPasteAccesses, id)
+		}
+	}
+
+	delete(m.teams, team.ID)
+
+	return nil
+}
+
+func (m *Memory) CreateTeamMembershipRecord(membership *models.TeamMembership) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.teamMemberships {
+		if existing.ID == membership.ID ||
+			(existing.TeamID == membership.TeamID &&
+				existing.UserID == membership.UserID) {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(membership)
+	m.teamMemberships[membership.ID] = *membership
+
+	return nil
+}
+
+func (m *Memory) GetTeamMemberships(teamId uuid.UUID) ([]models.TeamMembership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	memberships := []models.TeamMembership{}
+
+	for _, membership := range m.teamMemberships {
+		if membership.TeamID == teamId {
+			memberships = append(memberships, membership)
+		}
+	}
+
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].CreatedAt.Before(memberships[j].CreatedAt)
+	})
+
+	return memberships, nil
+}
+
+func (m *Memory) DeleteTeamMembership(teamId uuid.UUID, userId uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, membership := range m.teamMemberships {
+		if membership.TeamID == teamId && membership.UserID == userId {
+			delete(m.teamMemberships, id)
+
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (m *Memory) CreateTeamPasteAccessRecord(access *models.TeamPasteAccess) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.teamPasteAccesses {
+		if existing.ID == access.ID ||
+			(existing.TeamID == access.TeamID && existing.PasteID == access.PasteID) {
+			return ErrDuplicate
+		}
+	}
+
+	setTimestamps(access)
+	m.teamPasteAccesses[access.ID] = *access
+
+	return nil
+}
+
+func (m *Memory) DeleteTeamPasteAccess(teamId uuid.UUID, pasteId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, access := range m.teamPasteAccesses {
+		if access.TeamID == teamId && access.PasteID == pasteId {
+			delete(m.teamPasteAccesses, id)
+
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (m *Memory) teamPasteRoles(userId uuid.UUID, pasteId string) []string {
+	var roles []string
+
+	for _, access := range m.teamPasteAccesses {
+		if access.PasteID != pasteId {
+			continue
+		}
+
+		for _, membership := range m.teamMemberships {
+			if membership.TeamID == access.TeamID && membership.UserID == userId {
+				roles = append(roles, access.Role)
+			}
+		}
+	}
+
+	return roles
+}
+
+func (m *Memory) GetTeamPasteRoles(userId uuid.UUID, pasteId string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.teamPasteRoles(userId, pasteId), nil
+}
+
+func (m *Memory) GetTeamPasteAccessRecordsByPasteId(pasteId string) (
+	[]models.TeamPasteAccess, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accesses := []models.TeamPasteAccess{}
+
+	for _, access := range m.teamPasteAccesses {
+		if access.PasteID == pasteId {
+			accesses = append(accesses, access)
+		}
+	}
+
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].CreatedAt.Before(accesses[j].CreatedAt)
+	})
+
+	return accesses, nil
+}
+
+func (m *Memory) UpdateTeamPasteAccessRole(teamId uuid.UUID, pasteId string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, access := range m.teamPasteAccesses {
+		if access.TeamID == teamId && access.PasteID == pasteId {
+			access.Role = role
+			m.teamPasteAccesses[id] = access
+
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (m *Memory) GetDanglingTeamPasteAccessRecords() ([]models.TeamPasteAccess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accesses := []models.TeamPasteAccess{}
+
+	for _, access := range m.teamPasteAccesses {
+		_, pasteFound := m.pastes[access.PasteID]
+		_, teamFound := m.teams[access.TeamID]
+
+		if !pasteFound || !teamFound {
+			accesses = append(accesses, access)
+		}
+	}
+
+	return accesses, nil
+}
+
+func (m *Memory) CreateShareLinkRecord(link *models.ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.shareLinks[link.ID]; found {
+		return ErrDuplicate
+	}
+
+	setTimestamps(link)
+	m.shareLinks[link.ID] = *link
+
+	return nil
+}
+
+func (m *Memory) GetShareLinkByID(id uuid.UUID) (*models.ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, found := m.shareLinks[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return &link, nil
+}
+
+func (m *Memory) GetShareLinksByPasteId(pasteId string) ([]models.ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := []models.ShareLink{}
+
+	for _, link := range m.shareLinks {
+		if link.PasteID == pasteId {
+			links = append(links, link)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+
+	return links, nil
+}
+
+func (m *Memory) UseShareLink(id uuid.UUID, usedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, found := m.shareLinks[id]
+	if !found || link.RevokedAt != nil ||
+		(link.ExpiresAt != nil && !link.ExpiresAt.After(usedAt)) ||
+		(link.MaxViews != 0 && link.Views >= link.MaxViews) {
+		return false, nil
+	}
+
+	link.Views++
+	link.LastUsedAt = &usedAt
+	m.shareLinks[id] = link
+
+	return true, nil
+}
+
+func (m *Memory) RevokeShareLink(pasteId string, id uuid.UUID, revokedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, found := m.shareLinks[id]
+	if !found || link.PasteID != pasteId || link.RevokedAt != nil {
+		return ErrNotFound
+	}
+
+	link.RevokedAt = &revokedAt
+	m.shareLinks[id] = link
+
+	return nil
+}
+
+func (m *Memory) DeleteShareLinkRecord(link *models.ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.shareLinks, link.ID)
+
+	return nil
+}
+
+func (m *Memory) GetDanglingShareLinks() ([]models.ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := []models.ShareLink{}
+
+	for _, link := range m.shareLinks {
+		if _, found := m.pastes[link.PasteID]; !found {
+			links = append(links, link)
+		}
+	}
+
+	return links, nil
+}
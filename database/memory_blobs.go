@@ -0,0 +1,97 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// scheduleBlobDeletions records blob deletions, moving the time of any
+// already recorded to notBefore. The caller holds the lock.
+func (m *Memory) scheduleBlobDeletions(keys []string, notBefore time.Time) {
+	for _, key := range keys {
+		deletion, found := m.blobDeletions[key]
+		if !found {
+			deletion = models.BlobDeletion{Key: key, CreatedAt: time.Now()}
+		}
+
+		deletion.NotBefore = notBefore
+		m.blobDeletions[key] = deletion
+	}
+}
+
+func (m *Memory) ScheduleBlobDeletions(keys []string, notBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scheduleBlobDeletions(keys, notBefore)
+
+	return nil
+}
+
+func (m *Memory) GetDueBlobDeletions(now time.Time, limit int) (
+	[]models.BlobDeletion, error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deletions := []models.BlobDeletion{}
+
+	for _, deletion := range m.blobDeletions {
+		if !deletion.NotBefore.After(now) {
+			deletions = append(deletions, deletion)
+		}
+	}
+
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].NotBefore.Before(deletions[j].NotBefore)
+	})
+
+	if len(deletions) > limit {
+		deletions = deletions[:limit]
+	}
+
+	return deletions, nil
+}
+
+func (m *Memory) CompleteBlobDeletion(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blobDeletions, key)
+
+	return nil
+}
+
+func (m *Memory) RetryBlobDeletion(key string, lastError string, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deletion, found := m.blobDeletions[key]
+	if found {
+		deletion.NotBefore = retryAt
+		deletion.Attempts++
+		deletion.LastError = lastError
+		m.blobDeletions[key] = deletion
+	}
+
+	return nil
+}
+
+func (m *Memory) GetBlobDeletions() ([]models.BlobDeletion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deletions := []models.BlobDeletion{}
+
+	for _, deletion := range m.blobDeletions {
+		deletions = append(deletions, deletion)
+	}
+
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].NotBefore.Before(deletions[j].NotBefore)
+	})
+
+	return deletions, nil
+}
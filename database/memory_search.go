@@ -0,0 +1,335 @@
+package database
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Content snippets show this many bytes either side of the first match.
+const memorySnippetContext = 80
+
+// sharedWith reports whether a paste is shared with a user, directly,
+// through a team or through an organization, where they would see it in
+// listings. The caller holds the lock.
+func (m *Memory) sharedWith(userId uuid.UUID, paste *models.Paste) bool {
+	if paste.UserID == userId || !paste.Visibility.Listed() {
+		return false
+	}
+
+	for _, access := range m.pasteAccesses {
+		if access.PasteID == paste.ID && access.UserID == userId {
+			return true
+		}
+	}
+
+	if len(m.teamPasteRoles(userId, paste.ID)) > 0 {
+		return true
+	}
+
+	if paste.OrganizationID == nil {
+		return false
+	}
+
+	for _, membership := range m.orgMemberships {
+		if membership.OrganizationID == *paste.OrganizationID &&
+			membership.UserID == userId {
+			return membership.Role == models.OrganizationAdmin ||
+				paste.Visibility == models.OrgOnly
+		}
+	}
+
+	return false
+}
+
+func pasteSortValue(paste *models.Paste, sort string) interface{} {
+	switch sort {
+	case SortUpdatedAt:
+		return paste.UpdatedAt
+	case SortTitle:
+		return paste.Title
+	}
+
+	return paste.CreatedAt
+}
+
+func compareSortValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+
+	return 0
+}
+
+// comparePastes orders two pastes by the listing sort, breaking ties by ID.
+func comparePastes(a *models.Paste, b *models.Paste, sort string) int {
+	cmp := compareSortValues(pasteSortValue(a, sort), pasteSortValue(b, sort))
+	if cmp != 0 {
+		return cmp
+	}
+
+	return strings.Compare(a.ID, b.ID)
+}
+
+// Titles are compared byte by byte rather than by the database collation.
+func (m *Memory) ListPastes(opts PasteListOptions) ([]models.Paste, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pastes := []models.Paste{}
+
+	for _, paste := range m.pastes {
+		if paste.Expired() {
+			continue
+		}
+
+		owned := paste.UserID == opts.UserID
+
+		switch {
+		case opts.Owned == nil:
+			if !owned && !m.sharedWith(opts.UserID, &paste) {
+				continue
+			}
+		case *opts.Owned:
+			if !owned {
+				continue
+			}
+		default:
+			if !m.sharedWith(opts.UserID, &paste) {
+				continue
+			}
+		}
+
+		if opts.Visibility != nil && paste.Visibility != *opts.Visibility {
+			continue
+		}
+
+		if opts.Language != "" && paste.Language != opts.Language {
+			continue
+		}
+
+		if opts.CreatedAfter != nil && paste.CreatedAt.Before(*opts.CreatedAfter) {
+			continue
+		}
+
+		if opts.CreatedBefore != nil && !paste.CreatedAt.Before(*opts.CreatedBefore) {
+			continue
+		}
+
+		if !strings.HasPrefix(
+			strings.ToLower(paste.Title), strings.ToLower(opts.TitlePrefix),
+		) {
+			continue
+		}
+
+		if opts.After != nil {
+			cmp := compareSortValues(pasteSortValue(&paste, opts.Sort), opts.After.Value)
+			if cmp == 0 {
+				cmp = strings.Compare(paste.ID, opts.After.ID)
+			}
+
+			if cmp == 0 || (cmp < 0) != opts.Descending {
+				continue
+			}
+		}
+
+		pastes = append(pastes, paste)
+	}
+
+	sort.Slice(pastes, func(i, j int) bool {
+		cmp := comparePastes(&pastes[i], &pastes[j], opts.Sort)
+
+		if opts.Descending {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	})
+
+	if len(pastes) > opts.Limit {
+		pastes = pastes[:opts.Limit]
+	}
+
+	return pastes, nil
+}
+
+func (m *Memory) IndexPasteContent(pasteId string, title string, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.searchDocuments[pasteId] = searchDocument{title: title, content: content}
+
+	return nil
+}
+
+func (m *Memory) UpdatePasteSearchTitle(pasteId string, title string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document, found := m.searchDocuments[pasteId]
+	if found {
+		document.title = title
+		m.searchDocuments[pasteId] = document
+	}
+
+	return nil
+}
+
+// parseSearchQuery compiles the terms that must match and those that must
+// not. Terms after an "or" are required like any other.
+func parseSearchQuery(query string) (
+	included []*regexp.Regexp, excluded []*regexp.Regexp,
+) {
+	for _, term := range parseWebSearch(query) {
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(term.Text))
+
+		if term.Exclude {
+			excluded = append(excluded, pattern)
+		} else {
+			included = append(included, pattern)
+		}
+	}
+
+	return included, excluded
+}
+
+func markSearchMatches(text string, terms []*regexp.Regexp, start string, stop string) string {
+	for _, term := range terms {
+		text = term.ReplaceAllString(text, start+"${0}"+stop)
+	}
+
+	return text
+}
+
+// contentSnippet cuts the content around its first match.
+func contentSnippet(content string, terms []*regexp.Regexp) string {
+	first := -1
+
+	for _, term := range terms {
+		match := term.FindStringIndex(content)
+		if match != nil && (first == -1 || match[0] < first) {
+			first = match[0]
+		}
+	}
+
+	if first == -1 {
+		first = 0
+	}
+
+	from := max(first-memorySnippetContext, 0)
+	to := min(first+2*memorySnippetContext, len(content))
+
+	for from > 0 && !utf8.RuneStart(content[from]) {
+		from--
+	}
+
+	for to < len(content) && !utf8.RuneStart(content[to]) {
+		to++
+	}
+
+	return content[from:to]
+}
+
+// canSearchPaste applies the visibility rules of SearchPastes. The caller
+// holds the lock.
+func (m *Memory) canSearchPaste(userId uuid.UUID, paste *models.Paste) bool {
+	owned := paste.UserID == userId
+
+	if paste.Expired() || paste.BurnAfterReading {
+		return false
+	}
+
+	if !owned && (!paste.Visibility.Listed() || paste.PasswordHash != "") {
+		return false
+	}
+
+	return owned || paste.Visibility == models.Public || m.sharedWith(userId, paste)
+}
+
+// SearchPastes matches words and quoted phrases anywhere in the title or
+// content, ignoring case. Words prefixed with - exclude pastes containing
+// them. Title matches rank above content matches.
+func (m *Memory) SearchPastes(opts PasteSearchOptions) ([]PasteSearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []PasteSearchResult{}
+
+	included, excluded := parseSearchQuery(opts.Query)
+	if len(included) == 0 {
+		return results, nil
+	}
+
+	for id, document := range m.searchDocuments {
+		paste, found := m.pastes[id]
+		if !found || !m.canSearchPaste(opts.UserID, &paste) {
+			continue
+		}
+
+		rank := 0.0
+		matched := true
+
+		for _, term := range included {
+			titleMatches := len(term.FindAllStringIndex(document.title, -1))
+			contentMatches := len(term.FindAllStringIndex(document.content, -1))
+
+			if titleMatches+contentMatches == 0 {
+				matched = false
+
+				break
+			}
+
+			rank += float64(2*titleMatches + contentMatches)
+		}
+
+		for _, term := range excluded {
+			if term.MatchString(document.title) || term.MatchString(document.content) {
+				matched = false
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		results = append(results, PasteSearchResult{
+			Paste: paste,
+			Rank:  rank,
+			TitleSnippet: markSearchMatches(
+				paste.Title, included, opts.MatchStart, opts.MatchStop,
+			),
+			Snippet: markSearchMatches(
+				contentSnippet(document.content, included),
+				included, opts.MatchStart, opts.MatchStop,
+			),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+
+		return results[i].Paste.ID < results[j].Paste.ID
+	})
+
+	if opts.Offset >= len(results) {
+		return []PasteSearchResult{}, nil
+	}
+
+	results = results[opts.Offset:]
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are kept per dialect under migrations/<dialect>, as
+// <version>_<name>.up.sql and <version>_<name>.down.sql. Versions are
+// applied in order and never renumbered once released.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// The table recording applied migrations is created outside of them. SQLite
+// only reads columns declared as datetime back as times.
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at %s NOT NULL
+)`
+
+type Migration struct {
+	Version int
+	Name    string
+
+	up   string
+	down string
+}
+
+// MigrationState is a known migration and when it was applied, if it has
+// been.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (s *SQL) dialect() string {
+	if s.sqlite {
+		return "sqlite"
+	}
+
+	return "postgres"
+}
+
+// migrations reads the migrations of the store's dialect, in version order.
+func (s *SQL) migrations() ([]Migration, error) {
+	dir := path.Join("migrations", s.dialect())
+
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf(
+				"migration %d is named both %q and %q",
+				version, migration.Name, match[2],
+			)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf(
+				"migration %04d_%s needs both an up and a down file",
+				migration.Version, migration.Name,
+			)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedMigrations returns the migrations recorded in the database, in
+// version order.
+func (s *SQL) appliedMigrations() ([]schemaMigration, error) {
+	timeType := "timestamptz"
+	if s.sqlite {
+		timeType = "datetime"
+	}
+
+	err := s.db.Exec(fmt.Sprintf(schemaMigrationsTable, timeType)).Error
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []schemaMigration{}
+
+	result := s.db.Table("schema_migrations").Order("version").Find(&applied)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations that haven't been applied yet. It
+// fails if the database has been migrated past the migrations this build
+// knows, since it would be running against a schema it wasn't written for.
+func (s *SQL) pendingMigrations() ([]Migration, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+
+	if len(applied) > 0 && applied[len(applied)-1].Version > latest {
+		return nil, fmt.Errorf(
+			"database schema is at version %d, newer than this build's %d; "+
+				"upgrade the server or run migrate down with the newer build",
+			applied[len(applied)-1].Version, latest,
+		)
+	}
+
+	done := map[int]bool{}
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	pending := []Migration{}
+
+	for _, migration := range migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// RequireMigrated fails unless every migration this build knows has been
+// applied. Unlike the other migration methods it never changes the schema,
+// not even to record migrations.
+func (s *SQL) RequireMigrated() error {
+	known, err := s.migrations()
+	if err != nil {
+		return err
+	}
+
+	if !s.db.Migrator().HasTable("schema_migrations") {
+		if len(known) == 0 {
+			return nil
+		}
+
+		return errors.New("database schema isn't migrated; run migrate up first")
+	}
+
+	pending, err := s.pendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf(
+			"database schema has %d pending migrations; run migrate up first",
+			len(pending),
+		)
+	}
+
+	return nil
+}
+
+// autoMigrateColumns are the columns added to the tables of the first
+// models, which AutoMigrate created before there were migrations. Legacy rows
+// get the defaults, which are what the code writes for an unset field.
+var autoMigrateColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"pastes", "expires_at", "datetime"},
+	{"pastes", "burn_after_reading", "numeric DEFAULT false"},
+	{"pastes", "size", "integer DEFAULT 0"},
+	{"pastes", "content_hash", "text DEFAULT ''"},
+	{"pastes", "language", "text DEFAULT ''"},
+	{"pastes", "revision", "integer DEFAULT 0"},
+	{"pastes", "blob_key", "text DEFAULT ''"},
+	{"pastes", "edit_token_hash", "text DEFAULT ''"},
+	{"pastes", "password_hash", "text DEFAULT ''"},
+	{"pastes", "organization_id", "text"},
+	{"pastes", "encrypted", "numeric DEFAULT false"},
+	{"pastes", "encryption_algorithm", "text DEFAULT ''"},
+	{"paste_accesses", "role", "text DEFAULT ''"},
+}
+
+// upgradeAutoMigrateSchema adds the columns the initial SQLite migration
+// expects to the tables AutoMigrate created without them. Postgres does the
+// same in the migration itself with ADD COLUMN IF NOT EXISTS, which SQLite
+// doesn't have.
+func upgradeAutoMigrateSchema(tx *gorm.DB) error {
+	for _, added := range autoMigrateColumns {
+		var columns []string
+
+		err := tx.Raw(
+			"SELECT name FROM pragma_table_info(?)", added.table,
+		).Scan(&columns).Error
+		if err != nil {
+			return err
+		}
+
+		// Tables that don't exist yet are created whole by the migration.
+		if len(columns) == 0 || slices.Contains(columns, added.column) {
+			continue
+		}
+
+		err = tx.Exec(fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN %s %s",
+			added.table, added.column, added.definition,
+		)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction,
+// and returns the ones it applied.
+func (s *SQL) MigrateUp() ([]Migration, error) {
+	pending, err := s.pendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range pending {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if s.sqlite && migration.Version == 1 {
+				err := upgradeAutoMigrateSchema(tx)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := tx.Exec(migration.up).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Table("schema_migrations").Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf(
+				"migration %04d_%s: %w", migration.Version, migration.Name, err,
+			)
+		}
+	}
+
+	return pending, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first, and
+// returns the ones it reverted.
+func (s *SQL) MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[int]Migration{}
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+
+	reverted := []Migration{}
+
+	for i := len(applied) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration, found := known[applied[i].Version]
+		if !found {
+			return reverted, fmt.Errorf(
+				"migration %04d_%s is newer than this build; revert it with the build that added it",
+				applied[i].Version, applied[i].Name,
+			)
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec(migration.down).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Exec(
+				"DELETE FROM schema_migrations WHERE version = ?", migration.Version,
+			).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf(
+				"reverting migration %04d_%s: %w", migration.Version, migration.Name, err,
+			)
+		}
+
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// MigrationStatus lists every migration this build knows and whether it
+// has been applied, followed by any applied by a newer build.
+func (s *SQL) MigrationStatus() ([]MigrationState, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	states := []MigrationState{}
+
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+
+		if at, found := appliedAt[migration.Version]; found {
+			state.AppliedAt = &at
+			delete(appliedAt, migration.Version)
+		}
+
+		states = append(states, state)
+	}
+
+	// Migrations applied by a newer build are listed by their recorded name.
+	for _, migration := range applied {
+		if at, unknown := appliedAt[migration.Version]; unknown {
+			states = append(states, MigrationState{
+				Migration: Migration{Version: migration.Version, Name: migration.Name},
+				AppliedAt: &at,
+			})
+		}
+	}
+
+	return states, nil
+}
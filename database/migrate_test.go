@@ -0,0 +1,171 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// The models of the baseline commit, which AutoMigrate created the tables
+// of before there were migrations.
+type baselineUser struct {
+	ID        uuid.UUID `gorm:"primaryKey"`
+	Email     string    `gorm:"unique"`
+	Password  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselinePaste struct {
+	ID         string `gorm:"primaryKey"`
+	Title      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Visibility int
+	UserID     uuid.UUID
+}
+
+func (baselinePaste) TableName() string { return "pastes" }
+
+type baselinePasteAccess struct {
+	ID        uuid.UUID `gorm:"primaryKey"`
+	PasteID   string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselinePasteAccess) TableName() string { return "paste_accesses" }
+
+func TestMigrateUpUpgradesBaselineSchema(t *testing.T) {
+	db, err := ConnectSQL("sqlite:" + filepath.Join(t.TempDir(), "pastey.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.db.AutoMigrate(&baselineUser{}, &baselinePaste{}, &baselinePasteAccess{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := baselineUser{ID: uuid.New(), Email: "owner@example.com", Password: "x"}
+	friend := baselineUser{ID: uuid.New(), Email: "friend@example.com", Password: "x"}
+	paste := baselinePaste{
+		ID: uuid.NewString(), Title: "legacy", Visibility: 1, UserID: owner.ID,
+	}
+
+	for _, record := range []interface{}{
+		&owner, &friend, &paste,
+		&baselinePasteAccess{ID: uuid.New(), PasteID: paste.ID, UserID: owner.ID},
+		&baselinePasteAccess{ID: uuid.New(), PasteID: paste.ID, UserID: friend.ID},
+	} {
+		err = db.db.Create(record).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := db.pendingMigrations()
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("pending migrations after MigrateUp: %v, %v", pending, err)
+	}
+
+	migrated, err := db.GetPasteByID(paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.Title != "legacy" || migrated.Visibility != models.Private ||
+		migrated.UserID != owner.ID || migrated.BurnAfterReading ||
+		migrated.ExpiresAt != nil || migrated.StorageKey() != paste.ID {
+		t.Errorf("legacy paste read back as %+v", migrated)
+	}
+
+	access, err := db.GetPasteAccessRecordByUserIdAndPasteId(friend.ID, paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.AccessRole() != models.RoleViewer {
+		t.Errorf("legacy grant has role %q, want viewer", access.AccessRole())
+	}
+
+	listed, err := db.ListPastes(PasteListOptions{
+		UserID: friend.ID,
+		Sort:   SortCreatedAt,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listed) != 1 || listed[0].ID != paste.ID {
+		t.Errorf("friend's listing is %v, want the legacy paste", listed)
+	}
+
+	// The upgraded tables take new pastes like a fresh database.
+	store := &Store{Users: db, Pastes: db, Access: db}
+	user, err := db.GetUserByID(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := createTestPaste(t, store, user, models.Paste{Title: "new"})
+
+	revision := commitTestRevision(t, store, migrated)
+	if revision.Number != 2 {
+		t.Errorf("first edit of the legacy paste is revision %d, want 2", revision.Number)
+	}
+
+	_, err = db.GetPasteByID(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireMigratedLeavesTheSchemaAlone(t *testing.T) {
+	db, err := ConnectSQL("sqlite:" + filepath.Join(t.TempDir(), "pastey.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RequireMigrated()
+	if err == nil {
+		t.Fatal("RequireMigrated accepted an empty database")
+	}
+
+	if db.db.Migrator().HasTable("schema_migrations") {
+		t.Error("RequireMigrated created the schema_migrations table")
+	}
+
+	_, err = db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RequireMigrated()
+	if err != nil {
+		t.Errorf("RequireMigrated after MigrateUp: %v", err)
+	}
+
+	_, err = db.MigrateDown(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RequireMigrated()
+	if err == nil {
+		t.Error("RequireMigrated accepted a database with a pending migration")
+	}
+}
@@ -0,0 +1,13 @@
+DROP TABLE paste_search_documents;
+DROP TABLE share_links;
+DROP TABLE team_paste_accesses;
+DROP TABLE team_memberships;
+DROP TABLE teams;
+DROP TABLE organization_memberships;
+DROP TABLE organizations;
+DROP TABLE api_tokens;
+DROP TABLE revoked_tokens;
+DROP TABLE paste_revisions;
+DROP TABLE paste_accesses;
+DROP TABLE pastes;
+DROP TABLE users;
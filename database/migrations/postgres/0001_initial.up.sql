@@ -0,0 +1,186 @@
+-- Databases created before migrations were introduced have the tables
+-- AutoMigrate made from the first models: users, pastes and paste_accesses
+-- with only their original columns, and uuid columns stored as bytea. Those
+-- tables are created with their original columns and upgraded in place, so
+-- new and existing databases end up with the same schema. Added columns
+-- default to what the code writes for an unset field, which is what legacy
+-- rows are read as.
+CREATE TABLE IF NOT EXISTS users (
+	id text PRIMARY KEY,
+	email text UNIQUE,
+	password text,
+	created_at timestamptz,
+	updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS pastes (
+	id text PRIMARY KEY,
+	title text,
+	created_at timestamptz,
+	updated_at timestamptz,
+	visibility bigint,
+	user_id text
+);
+
+ALTER TABLE pastes
+	ADD COLUMN IF NOT EXISTS expires_at timestamptz,
+	ADD COLUMN IF NOT EXISTS burn_after_reading boolean DEFAULT false,
+	ADD COLUMN IF NOT EXISTS size bigint DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS content_hash text DEFAULT '',
+	ADD COLUMN IF NOT EXISTS language text DEFAULT '',
+	ADD COLUMN IF NOT EXISTS revision bigint DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS blob_key text DEFAULT '',
+	ADD COLUMN IF NOT EXISTS edit_token_hash text DEFAULT '',
+	ADD COLUMN IF NOT EXISTS password_hash text DEFAULT '',
+	ADD COLUMN IF NOT EXISTS organization_id text,
+	ADD COLUMN IF NOT EXISTS encrypted boolean DEFAULT false,
+	ADD COLUMN IF NOT EXISTS encryption_algorithm text DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_pastes_expires_at ON pastes (expires_at);
+CREATE INDEX IF NOT EXISTS idx_pastes_user_id ON pastes (user_id);
+CREATE INDEX IF NOT EXISTS idx_pastes_created_at ON pastes (created_at);
+CREATE INDEX IF NOT EXISTS idx_pastes_organization_id ON pastes (organization_id);
+
+CREATE TABLE IF NOT EXISTS paste_accesses (
+	id text PRIMARY KEY,
+	paste_id text,
+	user_id text,
+	created_at timestamptz,
+	updated_at timestamptz
+);
+
+ALTER TABLE paste_accesses ADD COLUMN IF NOT EXISTS role text DEFAULT '';
+
+-- AutoMigrate stored uuids as bytea holding their text form.
+DO $$
+DECLARE
+	col record;
+BEGIN
+	FOR col IN
+		SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		AND table_name IN ('users', 'pastes', 'paste_accesses')
+		AND data_type = 'bytea'
+	LOOP
+		EXECUTE format(
+			'ALTER TABLE %I ALTER COLUMN %I TYPE text USING convert_from(%I, ''UTF8'')',
+			col.table_name, col.column_name, col.column_name
+		);
+	END LOOP;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS paste_revisions (
+	id text PRIMARY KEY,
+	paste_id text,
+	number bigint,
+	user_id text,
+	size bigint,
+	content_hash text,
+	language text,
+	blob_key text,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_paste_revision_number
+	ON paste_revisions (paste_id, number);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti text PRIMARY KEY,
+	expires_at timestamptz,
+	created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id text PRIMARY KEY,
+	user_id text,
+	name text,
+	prefix text,
+	token_hash text,
+	scopes text,
+	last_used_at timestamptz,
+	expires_at timestamptz,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS organizations (
+	id text PRIMARY KEY,
+	name text UNIQUE,
+	created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS organization_memberships (
+	id text PRIMARY KEY,
+	organization_id text,
+	user_id text,
+	role text,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_membership
+	ON organization_memberships (organization_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_organization_memberships_user_id
+	ON organization_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS teams (
+	id text PRIMARY KEY,
+	organization_id text,
+	name text,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_team_name ON teams (organization_id, name);
+
+CREATE TABLE IF NOT EXISTS team_memberships (
+	id text PRIMARY KEY,
+	team_id text,
+	user_id text,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_membership ON team_memberships (team_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_team_memberships_user_id ON team_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS team_paste_accesses (
+	id text PRIMARY KEY,
+	team_id text,
+	paste_id text,
+	role text,
+	created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_paste_access
+	ON team_paste_accesses (team_id, paste_id);
+CREATE INDEX IF NOT EXISTS idx_team_paste_accesses_paste_id
+	ON team_paste_accesses (paste_id);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	id text PRIMARY KEY,
+	paste_id text,
+	created_by text,
+	name text,
+	scope text,
+	max_views bigint,
+	views bigint,
+	expires_at timestamptz,
+	last_used_at timestamptz,
+	revoked_at timestamptz,
+	created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_paste_id ON share_links (paste_id);
+
+CREATE TABLE IF NOT EXISTS paste_search_documents (
+	paste_id text PRIMARY KEY,
+	content text,
+	vector tsvector,
+	updated_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_paste_search_vector
+	ON paste_search_documents USING gin (vector);
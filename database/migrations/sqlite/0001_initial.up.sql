@@ -0,0 +1,154 @@
+-- Tables AutoMigrate created before migrations were introduced are kept.
+-- SQLite can't add a column only if it is missing, so the columns later
+-- added to them are added beforehand by upgradeAutoMigrateSchema.
+CREATE TABLE IF NOT EXISTS users (
+	id text PRIMARY KEY,
+	email text UNIQUE,
+	password text,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS pastes (
+	id text PRIMARY KEY,
+	title text,
+	created_at datetime,
+	updated_at datetime,
+	visibility integer,
+	user_id text,
+	expires_at datetime,
+	burn_after_reading numeric DEFAULT false,
+	size integer DEFAULT 0,
+	content_hash text DEFAULT '',
+	language text DEFAULT '',
+	revision integer DEFAULT 0,
+	blob_key text DEFAULT '',
+	edit_token_hash text DEFAULT '',
+	password_hash text DEFAULT '',
+	organization_id text,
+	encrypted numeric DEFAULT false,
+	encryption_algorithm text DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_pastes_expires_at ON pastes (expires_at);
+CREATE INDEX IF NOT EXISTS idx_pastes_user_id ON pastes (user_id);
+CREATE INDEX IF NOT EXISTS idx_pastes_created_at ON pastes (created_at);
+CREATE INDEX IF NOT EXISTS idx_pastes_organization_id ON pastes (organization_id);
+
+CREATE TABLE IF NOT EXISTS paste_accesses (
+	id text PRIMARY KEY,
+	paste_id text,
+	user_id text,
+	role text DEFAULT '',
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS paste_revisions (
+	id text PRIMARY KEY,
+	paste_id text,
+	number integer,
+	user_id text,
+	size integer,
+	content_hash text,
+	language text,
+	blob_key text,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_paste_revision_number
+	ON paste_revisions (paste_id, number);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti text PRIMARY KEY,
+	expires_at datetime,
+	created_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id text PRIMARY KEY,
+	user_id text,
+	name text,
+	prefix text,
+	token_hash text,
+	scopes text,
+	last_used_at datetime,
+	expires_at datetime,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS organizations (
+	id text PRIMARY KEY,
+	name text UNIQUE,
+	created_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS organization_memberships (
+	id text PRIMARY KEY,
+	organization_id text,
+	user_id text,
+	role text,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_membership
+	ON organization_memberships (organization_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_organization_memberships_user_id
+	ON organization_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS teams (
+	id text PRIMARY KEY,
+	organization_id text,
+	name text,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_team_name ON teams (organization_id, name);
+
+CREATE TABLE IF NOT EXISTS team_memberships (
+	id text PRIMARY KEY,
+	team_id text,
+	user_id text,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_membership ON team_memberships (team_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_team_memberships_user_id ON team_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS team_paste_accesses (
+	id text PRIMARY KEY,
+	team_id text,
+	paste_id text,
+	role text,
+	created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_paste_access
+	ON team_paste_accesses (team_id, paste_id);
+CREATE INDEX IF NOT EXISTS idx_team_paste_accesses_paste_id
+	ON team_paste_accesses (paste_id);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	id text PRIMARY KEY,
+	paste_id text,
+	created_by text,
+	name text,
+	scope text,
+	max_views integer,
+	views integer,
+	expires_at datetime,
+	last_used_at datetime,
+	revoked_at datetime,
+	created_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_paste_id ON share_links (paste_id);
+
+-- SQLite has no tsvector, so search uses an FTS5 table instead.
+CREATE VIRTUAL TABLE IF NOT EXISTS paste_search_documents
+	USING fts5(paste_id UNINDEXED, title, content);
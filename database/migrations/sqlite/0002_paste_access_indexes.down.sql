@@ -0,0 +1,3 @@
+DROP INDEX idx_paste_accesses_user_id;
+DROP INDEX idx_paste_accesses_paste_id;
+DROP INDEX idx_paste_access_user;
@@ -0,0 +1,21 @@
+-- Sharing a paste twice used to add a second grant. Keep the most
+-- privileged grant of each user on a paste before making them unique.
+DELETE FROM paste_accesses WHERE id NOT IN (
+	SELECT id FROM (
+		SELECT id, ROW_NUMBER() OVER (
+			PARTITION BY paste_id, user_id
+			ORDER BY CASE role
+				WHEN 'owner' THEN 4
+				WHEN 'co-owner' THEN 3
+				WHEN 'editor' THEN 2
+				ELSE 1
+			END DESC, created_at
+		) AS position
+		FROM paste_accesses
+	) AS ranked
+	WHERE position = 1
+);
+
+CREATE UNIQUE INDEX idx_paste_access_user ON paste_accesses (paste_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_paste_accesses_paste_id ON paste_accesses (paste_id);
+CREATE INDEX IF NOT EXISTS idx_paste_accesses_user_id ON paste_accesses (user_id);
@@ -0,0 +1,9 @@
+CREATE TABLE blob_deletions (
+	key text PRIMARY KEY,
+	not_before datetime,
+	attempts integer,
+	last_error text,
+	created_at datetime
+);
+
+CREATE INDEX idx_blob_deletions_not_before ON blob_deletions (not_before);
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func (s *SQL) CreateOrganizationRecord(
+	organization *models.Organization, adminId uuid.UUID,
+) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(organization).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.OrganizationMembership{
+			ID:             uuid.New(),
+			OrganizationID: organization.ID,
+			UserID:         adminId,
+			Role:           models.OrganizationAdmin,
+		}).Error
+	})
+}
+
+func (s *SQL) GetOrganizationByID(id uuid.UUID) (*models.Organization, error) {
+	var organization models.Organization
+
+	result := s.db.Where("id = ?", id).First(&organization)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &organization, nil
+}
+
+func (s *SQL) GetOrganizationsByUserId(userId uuid.UUID) ([]models.Organization, error) {
+	organizations := []models.Organization{}
+
+	result := s.db.Joins(
+		"JOIN organization_memberships ON organization_memberships.organization_id = organizations.id",
+	).Where(
+		"organization_memberships.user_id = ?", userId,
+	).Order("organizations.name").Find(&organizations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return organizations, nil
+}
+
+func (s *SQL) CreateOrganizationMembershipRecord(
+	membership *models.OrganizationMembership,
+) error {
+	result := s.db.Create(&membership)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) (
+	*models.OrganizationMembership, error,
+) {
+	var membership models.OrganizationMembership
+
+	result := s.db.Where(
+		"organization_id = ? AND user_id = ?", organizationId, userId,
+	).First(&membership)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &membership, nil
+}
+
+func (s *SQL) GetOrganizationMemberships(organizationId uuid.UUID) (
+	[]models.OrganizationMembership, error,
+) {
+	memberships := []models.OrganizationMembership{}
+
+	result := s.db.Where(
+		"organization_id = ?", organizationId,
+	).Order("created_at").Find(&memberships)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return memberships, nil
+}
+
+func (s *SQL) DeleteOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(
+			"organization_id = ? AND user_id = ?", organizationId, userId,
+		).Delete(&models.OrganizationMembership{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where(
+			"user_id = ? AND team_id IN (?)", userId,
+			tx.Model(&models.Team{}).Select("id").Where(
+				"organization_id = ?", organizationId,
+			),
+		).Delete(&models.TeamMembership{}).Error
+	})
+}
+
+func (s *SQL) CreateTeamRecord(team *models.Team) error {
+	result := s.db.Create(&team)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetTeamByID(id uuid.UUID) (*models.Team, error) {
+	var team models.Team
+
+	result := s.db.Where("id = ?", id).First(&team)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &team, nil
+}
+
+func (s *SQL) GetTeamsByOrganizationId(organizationId uuid.UUID) ([]models.Team, error) {
+	teams := []models.Team{}
+
+	result := s.db.Where(
+		"organization_id = ?", organizationId,
+	).Order("name").Find(&teams)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return teams, nil
+}
+
+func (s *SQL) DeleteTeamRecord(team *models.Team) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(
+			"team_id = ?", team.ID,
+		).Delete(&models.TeamMembership{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where(
+			"team_id = ?", team.ID,
+		).Delete(&models.TeamPasteAccess{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(team).Error
+	})
+}
+
+func (s *SQL) CreateTeamMembershipRecord(membership *models.TeamMembership) error {
+	result := s.db.Create(&membership)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetTeamMemberships(teamId uuid.UUID) ([]models.TeamMembership, error) {
+	memberships := []models.TeamMembership{}
+
+	result := s.db.Where(
+		"team_id = ?", teamId,
+	).Order("created_at").Find(&memberships)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return memberships, nil
+}
+
+func (s *SQL) DeleteTeamMembership(teamId uuid.UUID, userId uuid.UUID) error {
+	result := s.db.Where(
+		"team_id = ? AND user_id = ?", teamId, userId,
+	).Delete(&models.TeamMembership{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *SQL) CreateTeamPasteAccessRecord(access *models.TeamPasteAccess) error {
+	result := s.db.Create(&access)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) DeleteTeamPasteAccess(teamId uuid.UUID, pasteId string) error {
+	result := s.db.Where(
+		"team_id = ? AND paste_id = ?", teamId, pasteId,
+	).Delete(&models.TeamPasteAccess{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *SQL) GetTeamPasteRoles(userId uuid.UUID, pasteId string) ([]string, error) {
+	var roles []string
+
+	result := s.db.Model(&models.TeamPasteAccess{}).Joins(
+		"JOIN team_memberships ON team_memberships.team_id = team_paste_accesses.team_id",
+	).Where(
+		"team_memberships.user_id = ? AND team_paste_accesses.paste_id = ?",
+		userId, pasteId,
+	).Pluck("team_paste_accesses.role", &roles)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return roles, nil
+}
+
+func (s *SQL) GetTeamPasteAccessRecordsByPasteId(pasteId string) (
+	[]models.TeamPasteAccess, error,
+) {
+	accesses := []models.TeamPasteAccess{}
+
+	result := s.db.Where(
+		"paste_id = ?", pasteId,
+	).Order("created_at").Find(&accesses)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return accesses, nil
+}
+
+func (s *SQL) UpdateTeamPasteAccessRole(teamId uuid.UUID, pasteId string, role string) error {
+	result := s.db.Model(&models.TeamPasteAccess{}).Where(
+		"team_id = ? AND paste_id = ?", teamId, pasteId,
+	).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *SQL) GetDanglingTeamPasteAccessRecords() ([]models.TeamPasteAccess, error) {
+	accesses := []models.TeamPasteAccess{}
+
+	result := s.db.Where(
+		"paste_id NOT IN (SELECT id FROM pastes)",
+	).Or(
+		"team_id NOT IN (SELECT id FROM teams)",
+	).Find(&accesses)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return accesses, nil
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Pastes are mostly code, so words are neither stemmed nor dropped as stop
+// words.
+const searchConfig = "simple"
+
+// Titles rank above content.
+const searchVector = `setweight(to_tsvector('` + searchConfig + `', ?), 'A') ||
+	setweight(to_tsvector('` + searchConfig + `', ?), 'B')`
+
+type PasteSearchResult struct {
+	Paste        models.Paste `json:"paste" gorm:"embedded"`
+	Rank         float64      `json:"rank"`
+	TitleSnippet string       `json:"title_snippet"`
+	Snippet      string       `json:"snippet"`
+}
+
+type PasteSearchOptions struct {
+	UserID uuid.UUID
+	Query  string
+	// Matches in snippets are wrapped in MatchStart and MatchStop.
+	MatchStart string
+	MatchStop  string
+	Limit      int
+	Offset     int
+}
+
+var webSearchTerm = regexp.MustCompile(`-?"[^"]*"?|\S+`)
+
+// searchTerm is a word or quoted phrase of a query in web search syntax.
+// Or is set when it follows an "or".
+type searchTerm struct {
+	Text    string
+	Exclude bool
+	Or      bool
+}
+
+func parseWebSearch(query string) []searchTerm {
+	var terms []searchTerm
+
+	or := false
+
+	for _, token := range webSearchTerm.FindAllString(query, -1) {
+		if strings.EqualFold(token, "or") {
+			or = true
+
+			continue
+		}
+
+		exclude := strings.HasPrefix(token, "-")
+
+		text := strings.Trim(strings.TrimPrefix(token, "-"), `"`)
+		if text == "" {
+			continue
+		}
+
+		terms = append(terms, searchTerm{Text: text, Exclude: exclude, Or: or})
+		or = false
+	}
+
+	return terms
+}
+
+func (s *SQL) IndexPasteContent(pasteId string, title string, content string) error {
+	if s.sqlite {
+		return s.indexSQLite(pasteId, title, content)
+	}
+
+	result := s.db.Exec(
+		`INSERT INTO paste_search_documents (paste_id, content, vector, updated_at)
+		VALUES (?, ?, `+searchVector+`, ?)
+		ON CONFLICT (paste_id) DO UPDATE SET
+			content = excluded.content,
+			vector = excluded.vector,
+			updated_at = excluded.updated_at`,
+		pasteId, content, title, content, time.Now(),
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) UpdatePasteSearchTitle(pasteId string, title string) error {
+	if s.sqlite {
+		return s.db.Exec(
+			"UPDATE paste_search_documents SET title = ? WHERE paste_id = ?",
+			title, pasteId,
+		).Error
+	}
+
+	result := s.db.Exec(
+		`UPDATE paste_search_documents SET
+			vector = setweight(to_tsvector('`+searchConfig+`', ?), 'A') ||
+				setweight(to_tsvector('`+searchConfig+`', content), 'B'),
+			updated_at = ?
+		WHERE paste_id = ?`,
+		title, time.Now(), pasteId,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// searchable narrows a search to the pastes a user can read that may appear
+// in search results.
+func (s *SQL) searchable(query *gorm.DB, userId uuid.UUID) *gorm.DB {
+	unlisted := []int{int(models.Unlisted), int(models.SharedWithLink)}
+
+	readable := s.db.Where(
+		"pastes.user_id = ?", userId,
+	).Or(
+		"pastes.visibility = ?", int(models.Public),
+	).Or(
+		s.sharedPastes(userId),
+	)
+
+	return query.Where(
+		"pastes.expires_at IS NULL OR pastes.expires_at > ?", time.Now(),
+	).Where(
+		"NOT pastes.burn_after_reading",
+	).Where(
+		"pastes.visibility NOT IN ? OR pastes.user_id = ?", unlisted, userId,
+	).Where(
+		"pastes.password_hash = '' OR pastes.user_id = ?", userId,
+	).Where(readable)
+}
+
+// SearchPastes takes queries in web search syntax, like
+// websearch_to_tsquery.
+func (s *SQL) SearchPastes(opts PasteSearchOptions) ([]PasteSearchResult, error) {
+	if s.sqlite {
+		return s.searchSQLite(opts)
+	}
+
+	results := []PasteSearchResult{}
+
+	headlineOptions := `StartSel="` + opts.MatchStart +
+		`", StopSel="` + opts.MatchStop + `"`
+
+	result := s.searchable(s.db.Table("pastes").Select(
+		`pastes.*,
+		ts_rank(paste_search_documents.vector, search.query) AS rank,
+		ts_headline(?, pastes.title, search.query, ?) AS title_snippet,
+		ts_headline(?, paste_search_documents.content, search.query, ?) AS snippet`,
+		searchConfig, headlineOptions+", HighlightAll=true",
+		searchConfig, headlineOptions+", MaxFragments=2",
+	).Joins(
+		"JOIN paste_search_documents ON paste_search_documents.paste_id = pastes.id",
+	).Joins(
+		"CROSS JOIN websearch_to_tsquery(?, ?) AS search(query)",
+		searchConfig, opts.Query,
+	).Where(
+		"paste_search_documents.vector @@ search.query",
+	), opts.UserID).Order(
+		"rank DESC, pastes.id",
+	).Limit(opts.Limit).Offset(opts.Offset).Scan(&results)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return results, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func TestSearchPastesLeavesOutHiddenPastes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		owner := createTestUser(t, store, "owner@example.com")
+		searcher := createTestUser(t, store, "searcher@example.com")
+
+		past := time.Now().Add(-time.Hour)
+		future := time.Now().Add(time.Hour)
+
+		pastes := map[string]*models.Paste{}
+
+		for name, paste := range map[string]models.Paste{
+			"public":      {Visibility: models.Public},
+			"expiring":    {Visibility: models.Public, ExpiresAt: &future},
+			"private":     {Visibility: models.Private},
+			"shared":      {Visibility: models.Private},
+			"unlisted":    {Visibility: models.Unlisted},
+			"link":        {Visibility: models.SharedWithLink},
+			"burning":     {Visibility: models.Public, BurnAfterReading: true},
+			"protected":   {Visibility: models.Public, PasswordHash: "hash"},
+			"expired":     {Visibility: models.Public, ExpiresAt: &past},
+			"shared link": {Visibility: models.SharedWithLink},
+		} {
+			paste.Title = name
+			pastes[name] = createTestPaste(t, store, owner, paste)
+
+			err := store.Pastes.IndexPasteContent(pastes[name].ID, name, "find the needle")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Sharing a paste lists it for the user, unless it is only meant to
+		// be found through its link.
+		for _, name := range []string{"shared", "shared link"} {
+			err := store.Access.CreatePasteAccessRecord(&models.PasteAccess{
+				ID:      uuid.New(),
+				PasteID: pastes[name].ID,
+				UserID:  searcher.ID,
+				Role:    models.RoleViewer,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, test := range []struct {
+			user *models.User
+			want []string
+		}{
+			{searcher, []string{"expiring", "public", "shared"}},
+			// Owners find their unlisted and protected pastes too, but not
+			// ones that are gone or about to be.
+			{owner, []string{
+				"expiring", "link", "private", "protected", "public",
+				"shared", "shared link", "unlisted",
+			}},
+		} {
+			results, err := store.Pastes.SearchPastes(PasteSearchOptions{
+				UserID: test.user.ID,
+				Query:  "needle",
+				Limit:  20,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := []string{}
+			for _, result := range results {
+				found = append(found, result.Paste.Title)
+			}
+
+			slices.Sort(found)
+
+			if !slices.Equal(found, test.want) {
+				t.Errorf("%s found %v, want %v", test.user.Email, found, test.want)
+			}
+		}
+	})
+}
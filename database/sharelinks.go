@@ -0,0 +1,100 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func (s *SQL) CreateShareLinkRecord(link *models.ShareLink) error {
+	result := s.db.Create(link)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetShareLinkByID(id uuid.UUID) (*models.ShareLink, error) {
+	var link models.ShareLink
+
+	result := s.db.Where("id = ?", id).First(&link)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &link, nil
+}
+
+func (s *SQL) GetShareLinksByPasteId(pasteId string) ([]models.ShareLink, error) {
+	links := []models.ShareLink{}
+
+	result := s.db.Where(
+		"paste_id = ?", pasteId,
+	).Order("created_at").Find(&links)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return links, nil
+}
+
+// The checks and the count happen in one statement so concurrent requests
+// can't overrun MaxViews.
+func (s *SQL) UseShareLink(id uuid.UUID, usedAt time.Time) (bool, error) {
+	result := s.db.Model(&models.ShareLink{}).Where(
+		"id = ? AND revoked_at IS NULL", id,
+	).Where(
+		"expires_at IS NULL OR expires_at > ?", usedAt,
+	).Where(
+		"max_views = 0 OR views < max_views",
+	).Updates(map[string]interface{}{
+		"views":        gorm.Expr("views + 1"),
+		"last_used_at": usedAt,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (s *SQL) RevokeShareLink(pasteId string, id uuid.UUID, revokedAt time.Time) error {
+	result := s.db.Model(&models.ShareLink{}).Where(
+		"id = ? AND paste_id = ? AND revoked_at IS NULL", id, pasteId,
+	).Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *SQL) DeleteShareLinkRecord(link *models.ShareLink) error {
+	result := s.db.Delete(link)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func (s *SQL) GetDanglingShareLinks() ([]models.ShareLink, error) {
+	links := []models.ShareLink{}
+
+	result := s.db.Where(
+		"paste_id NOT IN (SELECT id FROM pastes)",
+	).Find(&links)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return links, nil
+}
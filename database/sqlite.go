@@ -0,0 +1,133 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const sqliteScheme = "sqlite:"
+
+// Writers wait for each other rather than failing with SQLITE_BUSY, and
+// transactions take the write lock up front so two of them can't deadlock
+// upgrading their read locks.
+const sqliteOptions = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)" +
+	"&_txlock=immediate&_time_format=sqlite"
+
+// sqliteDialector binds every time in UTC. SQLite stores times as text and
+// compares them as strings, which only orders them correctly when they share
+// an offset.
+type sqliteDialector struct {
+	*sqlite.Dialector
+}
+
+func (d sqliteDialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	last := len(stmt.Vars) - 1
+
+	switch value := v.(type) {
+	case time.Time:
+		stmt.Vars[last] = value.UTC()
+	case *time.Time:
+		if value != nil {
+			stmt.Vars[last] = value.UTC()
+		}
+	}
+
+	d.Dialector.BindVarTo(writer, stmt, v)
+}
+
+// openSQLite opens the database file named by a sqlite: URL, such as
+// sqlite:pastey.db or sqlite:///var/lib/pastey/pastey.db.
+func openSQLite(url string) gorm.Dialector {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, sqliteScheme), "//")
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	return sqliteDialector{
+		Dialector: sqlite.Open(path + separator + sqliteOptions).(*sqlite.Dialector),
+	}
+}
+
+// sqliteSearchQuery turns a query in web search syntax into an FTS5 query,
+// quoting every term so that FTS5 operators in it are taken literally.
+// Queries with nothing to match return "".
+func sqliteSearchQuery(query string) string {
+	var matched, excluded []string
+
+	for _, term := range parseWebSearch(query) {
+		quoted := `"` + strings.ReplaceAll(term.Text, `"`, `""`) + `"`
+
+		switch {
+		case term.Exclude:
+			excluded = append(excluded, quoted)
+		case term.Or && len(matched) > 0:
+			matched = append(matched, "OR", quoted)
+		default:
+			matched = append(matched, quoted)
+		}
+	}
+
+	if len(matched) == 0 {
+		return ""
+	}
+
+	fts := "(" + strings.Join(matched, " ") + ")"
+
+	for _, term := range excluded {
+		fts += " NOT " + term
+	}
+
+	return fts
+}
+
+func (s *SQL) indexSQLite(pasteId string, title string, content string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(
+			"DELETE FROM paste_search_documents WHERE paste_id = ?", pasteId,
+		).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec(
+			`INSERT INTO paste_search_documents (paste_id, title, content)
+			VALUES (?, ?, ?)`,
+			pasteId, title, content,
+		).Error
+	})
+}
+
+// searchSQLite ranks with bm25, weighting titles ten times content.
+func (s *SQL) searchSQLite(opts PasteSearchOptions) ([]PasteSearchResult, error) {
+	results := []PasteSearchResult{}
+
+	query := sqliteSearchQuery(opts.Query)
+	if query == "" {
+		return results, nil
+	}
+
+	result := s.searchable(s.db.Table("pastes").Select(
+		`pastes.*,
+		-bm25(paste_search_documents, 0, 10, 1) AS rank,
+		highlight(paste_search_documents, 1, ?, ?) AS title_snippet,
+		snippet(paste_search_documents, 2, ?, ?, '…', 32) AS snippet`,
+		opts.MatchStart, opts.MatchStop, opts.MatchStart, opts.MatchStop,
+	).Joins(
+		"JOIN paste_search_documents ON paste_search_documents.paste_id = pastes.id",
+	).Where(
+		"paste_search_documents MATCH ?", query,
+	), opts.UserID).Order(
+		"rank DESC, pastes.id",
+	).Limit(opts.Limit).Offset(opts.Offset).Scan(&results)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return results, nil
+}
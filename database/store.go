@@ -0,0 +1,240 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// Every store reports missing records and duplicates with the same errors,
+// whatever its backend.
+var (
+	ErrNotFound  = gorm.ErrRecordNotFound
+	ErrDuplicate = gorm.ErrDuplicatedKey
+)
+
+// UserStore holds accounts and the credentials issued to them.
+type UserStore interface {
+	CreateUserRecord(user *models.User) error
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByID(id uuid.UUID) (*models.User, error)
+
+	// RevokeToken adds a token to the denylist until it expires. Revoking a
+	// token that is already revoked returns ErrDuplicate.
+	RevokeToken(jti string, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+	DeleteExpiredRevokedTokens(now time.Time) error
+
+	CreateAPITokenRecord(token *models.APIToken) error
+	GetAPITokensByUserId(userId uuid.UUID) ([]models.APIToken, error)
+	GetAPITokenByHash(tokenHash string) (*models.APIToken, error)
+	TouchAPIToken(id uuid.UUID, usedAt time.Time) error
+	DeleteAPITokenRecord(userId uuid.UUID, id uuid.UUID) error
+}
+
+// PasteStore holds pastes, their revisions and their search index, and the
+// blobs waiting to be deleted from storage.
+type PasteStore interface {
+	// CreatePaste creates a paste with its first revision and, unless owner
+	// is nil, its owner's grant, all in one transaction.
+	CreatePaste(
+		paste *models.Paste, revision *models.PasteRevision, owner *models.PasteAccess,
+	) error
+	GetPasteByID(id string) (*models.Paste, error)
+	// UpdatePasteRecord updates the non-zero fields of paste.
+	UpdatePasteRecord(pasteId string, paste *models.Paste) error
+	UpdatePasteExpiry(pasteId string, expiresAt *time.Time) error
+	// UpdatePastePassword sets the password hash of a paste, clearing it
+	// when passwordHash is empty.
+	UpdatePastePassword(pasteId string, passwordHash string) error
+	// UpdatePasteVisibility sets the visibility of a paste, including public,
+	// which UpdatePasteRecord can't as it is the zero value.
+	UpdatePasteVisibility(pasteId string, visibility models.Visibility) error
+	// DeletePaste deletes a paste and everything recorded about it, and
+	// schedules its blobs for deletion in the same transaction. It returns
+	// the keys of the blobs.
+	DeletePaste(paste *models.Paste) ([]string, error)
+	GetExpiredPastes(now time.Time, limit int) ([]models.Paste, error)
+	// ClaimBurnAfterReadingPaste deletes a burn-after-reading paste and
+	// everything recorded about it, reporting whether this caller was the
+	// one to delete it. Its blobs are scheduled for deletion once
+	// blobsNotBefore has passed, giving the caller time to serve them.
+	ClaimBurnAfterReadingPaste(pasteId string, blobsNotBefore time.Time) (bool, error)
+	// ListPastes returns a page of the pastes a user owns or that are shared
+	// with them, directly, through a team or through an organization.
+	// Pastes shared with them that aren't listed, and expired pastes, are
+	// left out.
+	ListPastes(opts PasteListOptions) ([]models.Paste, error)
+
+	// CommitPasteRevision numbers revision after the paste's latest revision
+	// and makes it the paste's current content. Pastes from before revisions
+	// existed first get a revision for their original content. Any scheduled
+	// deletion of the revision's blob is cancelled.
+	CommitPasteRevision(revision *models.PasteRevision) error
+	GetPasteRevisionsByPasteId(pasteId string) ([]models.PasteRevision, error)
+	GetPasteRevision(pasteId string, number int) (*models.PasteRevision, error)
+	// GetPasteBlobKeys lists the storage keys of every revision of a paste.
+	GetPasteBlobKeys(paste *models.Paste) ([]string, error)
+	DeletePasteRevisionRecord(revision *models.PasteRevision) error
+
+	// WalkPastes calls fn with every paste, fetching them batchSize at a
+	// time, and stops at the first error.
+	WalkPastes(batchSize int, fn func(paste *models.Paste) error) error
+	// WalkPasteRevisions calls fn with every revision of every paste, like
+	// WalkPastes.
+	WalkPasteRevisions(
+		batchSize int, fn func(revision *models.PasteRevision) error,
+	) error
+
+	// IndexPasteContent replaces the indexed title and content of a paste.
+	IndexPasteContent(pasteId string, title string, content string) error
+	// UpdatePasteSearchTitle reindexes a paste under a new title, keeping
+	// its indexed content.
+	UpdatePasteSearchTitle(pasteId string, title string) error
+	// SearchPastes ranks the pastes a user can read against a search query.
+	// Besides their own pastes, that is public ones, those shared with them
+	// directly or through a team, and those of their organizations they can
+	// read. Unlisted, expired and burn-after-reading pastes are left out, as
+	// are password-protected ones they don't own, since snippets would give
+	// their content away.
+	SearchPastes(opts PasteSearchOptions) ([]PasteSearchResult, error)
+
+	// ScheduleBlobDeletions records that blobs are to be deleted from storage
+	// once notBefore has passed. Committing a revision cancels the deletion
+	// of its blob, so uploads are scheduled before they start and only
+	// deleted if their records never make it.
+	ScheduleBlobDeletions(keys []string, notBefore time.Time) error
+	GetDueBlobDeletions(now time.Time, limit int) ([]models.BlobDeletion, error)
+	// CompleteBlobDeletion forgets a deletion once storage has confirmed it.
+	CompleteBlobDeletion(key string) error
+	// RetryBlobDeletion records a failed deletion and when to try it again.
+	RetryBlobDeletion(key string, lastError string, retryAt time.Time) error
+	// GetBlobDeletions lists every scheduled deletion, due or not.
+	GetBlobDeletions() ([]models.BlobDeletion, error)
+}
+
+// AccessStore holds who can use a paste: direct grants, organizations and
+// their teams, and share links.
+type AccessStore interface {
+	// CreatePasteAccessRecord grants a user a role on a paste. Users have at
+	// most one grant on each paste, so a second one returns ErrDuplicate.
+	CreatePasteAccessRecord(pasteAccess *models.PasteAccess) error
+	GetPasteAccessRecordsByPasteId(pasteId string) ([]models.PasteAccess, error)
+	GetPasteAccessRecordByUserIdAndPasteId(userId uuid.UUID, pasteId string) (
+		*models.PasteAccess, error,
+	)
+	UpdatePasteAccessRole(pasteAccess *models.PasteAccess, role string) error
+	DeletePasteAccessRecord(pasteAccess *models.PasteAccess) error
+	// GetDanglingPasteAccessRecords returns grants on pastes or to users
+	// that no longer exist.
+	GetDanglingPasteAccessRecords() ([]models.PasteAccess, error)
+
+	// CreateOrganizationRecord creates an organization with its creator as
+	// the first admin.
+	CreateOrganizationRecord(
+		organization *models.Organization, adminId uuid.UUID,
+	) error
+	GetOrganizationByID(id uuid.UUID) (*models.Organization, error)
+	GetOrganizationsByUserId(userId uuid.UUID) ([]models.Organization, error)
+	CreateOrganizationMembershipRecord(
+		membership *models.OrganizationMembership,
+	) error
+	GetOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) (
+		*models.OrganizationMembership, error,
+	)
+	GetOrganizationMemberships(organizationId uuid.UUID) (
+		[]models.OrganizationMembership, error,
+	)
+	// DeleteOrganizationMembership removes a user from an organization and
+	// from all of its teams.
+	DeleteOrganizationMembership(organizationId uuid.UUID, userId uuid.UUID) error
+
+	CreateTeamRecord(team *models.Team) error
+	GetTeamByID(id uuid.UUID) (*models.Team, error)
+	GetTeamsByOrganizationId(organizationId uuid.UUID) ([]models.Team, error)
+	// DeleteTeamRecord deletes a team along with its memberships and the
+	// pastes shared with it.
+	DeleteTeamRecord(team *models.Team) error
+	CreateTeamMembershipRecord(membership *models.TeamMembership) error
+	GetTeamMemberships(teamId uuid.UUID) ([]models.TeamMembership, error)
+	DeleteTeamMembership(teamId uuid.UUID, userId uuid.UUID) error
+
+	CreateTeamPasteAccessRecord(access *models.TeamPasteAccess) error
+	DeleteTeamPasteAccess(teamId uuid.UUID, pasteId string) error
+	// GetTeamPasteRoles returns the roles a paste is shared with on the
+	// teams the user is currently a member of.
+	GetTeamPasteRoles(userId uuid.UUID, pasteId string) ([]string, error)
+	GetTeamPasteAccessRecordsByPasteId(pasteId string) (
+		[]models.TeamPasteAccess, error,
+	)
+	UpdateTeamPasteAccessRole(teamId uuid.UUID, pasteId string, role string) error
+	// GetDanglingTeamPasteAccessRecords returns team grants on pastes or to
+	// teams that no longer exist.
+	GetDanglingTeamPasteAccessRecords() ([]models.TeamPasteAccess, error)
+
+	CreateShareLinkRecord(link *models.ShareLink) error
+	GetShareLinkByID(id uuid.UUID) (*models.ShareLink, error)
+	GetShareLinksByPasteId(pasteId string) ([]models.ShareLink, error)
+	// UseShareLink counts one use of a share link, reporting false if it
+	// has been revoked, has expired or has no views left.
+	UseShareLink(id uuid.UUID, usedAt time.Time) (bool, error)
+	RevokeShareLink(pasteId string, id uuid.UUID, revokedAt time.Time) error
+	DeleteShareLinkRecord(link *models.ShareLink) error
+	// GetDanglingShareLinks returns share links to pastes that no longer
+	// exist.
+	GetDanglingShareLinks() ([]models.ShareLink, error)
+}
+
+// Store is everything the server keeps outside of blob storage.
+type Store struct {
+	Users  UserStore
+	Pastes PasteStore
+	Access AccessStore
+}
+
+// Open opens the store for a backend:
+//   - sql (default) connects to DATABASE_URL, Postgres or SQLite depending
+//     on its scheme, and migrates it
+//   - memory keeps everything in process, for tests and trying things out;
+//     it is lost on restart
+func Open(backend string) (*Store, error) {
+	switch backend {
+	case "", "sql":
+		db, err := OpenSQL(os.Getenv("DATABASE_URL"))
+		if err != nil {
+			return nil, err
+		}
+
+		return &Store{Users: db, Pastes: db, Access: db}, nil
+	case "memory":
+		log.Println("Using the in-memory database; data is lost on restart")
+
+		memory := NewMemory()
+
+		return &Store{Users: memory, Pastes: memory, Access: memory}, nil
+	}
+
+	return nil, fmt.Errorf("unknown database backend %q", backend)
+}
+
+// Connect opens the SQL store at DATABASE_URL like Open, but leaves its
+// schema alone and fails if it has pending migrations.
+func Connect() (*Store, error) {
+	db, err := ConnectSQL(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.RequireMigrated()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{Users: db, Pastes: db, Access: db}, nil
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// forEachStore runs fn against the in-memory store and a fresh SQLite
+// database, so both backends are held to the same behaviour.
+func forEachStore(t *testing.T, fn func(t *testing.T, store *Store)) {
+	t.Run("memory", func(t *testing.T) {
+		memory := NewMemory()
+
+		fn(t, &Store{Users: memory, Pastes: memory, Access: memory})
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := OpenSQL("sqlite:" + filepath.Join(t.TempDir(), "pastey.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fn(t, &Store{Users: db, Pastes: db, Access: db})
+	})
+}
+
+func createTestUser(t *testing.T, store *Store, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{ID: uuid.New(), Email: email, Password: "x"}
+
+	err := store.Users.CreateUserRecord(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// createTestPaste creates a paste with its first revision, owned by owner
+// unless it is nil.
+func createTestPaste(
+	t *testing.T, store *Store, owner *models.User, paste models.Paste,
+) *models.Paste {
+	t.Helper()
+
+	paste.ID = uuid.NewString()
+
+	revision := models.PasteRevision{ID: uuid.New(), PasteID: paste.ID}
+	revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+	paste.BlobKey = revision.BlobKey
+
+	var access *models.PasteAccess
+
+	if owner != nil {
+		paste.UserID = owner.ID
+		revision.UserID = owner.ID
+		access = &models.PasteAccess{
+			ID:      uuid.New(),
+			PasteID: paste.ID,
+			UserID:  owner.ID,
+			Role:    models.RoleOwner,
+		}
+	}
+
+	err := store.Pastes.CreatePaste(&paste, &revision, access)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paste.Revision = revision.Number
+
+	return &paste
+}
+
+func commitTestRevision(t *testing.T, store *Store, paste *models.Paste) *models.PasteRevision {
+	t.Helper()
+
+	revision := &models.PasteRevision{ID: uuid.New(), PasteID: paste.ID}
+	revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+
+	err := store.Pastes.CommitPasteRevision(revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return revision
+}
+
+func TestRevisionNumbersFollowTheLatest(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		paste := createTestPaste(t, store, nil, models.Paste{})
+		if paste.Revision != 1 {
+			t.Fatalf("first revision is %d, want 1", paste.Revision)
+		}
+
+		second := commitTestRevision(t, store, paste)
+		third := commitTestRevision(t, store, paste)
+
+		if second.Number != 2 || third.Number != 3 {
+			t.Fatalf("revisions numbered %d and %d, want 2 and 3", second.Number, third.Number)
+		}
+
+		err := store.Pastes.DeletePasteRevisionRecord(second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fourth := commitTestRevision(t, store, paste)
+		if fourth.Number != 4 {
+			t.Errorf("revision after a deleted one is %d, want 4", fourth.Number)
+		}
+
+		revisions, err := store.Pastes.GetPasteRevisionsByPasteId(paste.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen := map[int]bool{}
+		for _, revision := range revisions {
+			if seen[revision.Number] {
+				t.Errorf("revision %d appears twice", revision.Number)
+			}
+
+			seen[revision.Number] = true
+		}
+	})
+}
+
+func TestDanglingTeamAccessesAndShareLinks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		owner := createTestUser(t, store, "owner@example.com")
+		paste := createTestPaste(t, store, owner, models.Paste{})
+
+		organization := &models.Organization{ID: uuid.New(), Name: "acme"}
+
+		err := store.Access.CreateOrganizationRecord(organization, owner.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		team := &models.Team{ID: uuid.New(), OrganizationID: organization.ID, Name: "core"}
+
+		err = store.Access.CreateTeamRecord(team)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accesses := []models.TeamPasteAccess{
+			{ID: uuid.New(), TeamID: team.ID, PasteID: paste.ID},
+			{ID: uuid.New(), TeamID: team.ID, PasteID: uuid.NewString()},
+			{ID: uuid.New(), TeamID: uuid.New(), PasteID: paste.ID},
+		}
+
+		for i := range accesses {
+			err = store.Access.CreateTeamPasteAccessRecord(&accesses[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		links := []models.ShareLink{
+			{ID: uuid.New(), PasteID: paste.ID, CreatedBy: owner.ID},
+			{ID: uuid.New(), PasteID: uuid.NewString(), CreatedBy: owner.ID},
+		}
+
+		for i := range links {
+			err = store.Access.CreateShareLinkRecord(&links[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		danglingAccesses, err := store.Access.GetDanglingTeamPasteAccessRecords()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := map[uuid.UUID]bool{}
+		for _, access := range danglingAccesses {
+			found[access.ID] = true
+		}
+
+		if len(found) != 2 || !found[accesses[1].ID] || !found[accesses[2].ID] {
+			t.Errorf("dangling team accesses are %v, want the last two", danglingAccesses)
+		}
+
+		danglingLinks, err := store.Access.GetDanglingShareLinks()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(danglingLinks) != 1 || danglingLinks[0].ID != links[1].ID {
+			t.Errorf("dangling share links are %v, want the second", danglingLinks)
+		}
+
+		err = store.Access.DeleteShareLinkRecord(&danglingLinks[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		remaining, err := store.Access.GetShareLinksByPasteId(links[1].PasteID)
+		if err != nil || len(remaining) != 0 {
+			t.Errorf("share link still there after deleting it: %v, %v", remaining, err)
+		}
+	})
+}
+
+func TestCreatePasteIsAllOrNothing(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		owner := createTestUser(t, store, "owner@example.com")
+		existing := createTestPaste(t, store, owner, models.Paste{})
+
+		access, err := store.Access.GetPasteAccessRecordByUserIdAndPasteId(owner.ID, existing.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The owner's grant reuses an existing ID, so it is inserted last
+		// and fails.
+		paste := &models.Paste{ID: uuid.NewString(), UserID: owner.ID}
+		revision := &models.PasteRevision{ID: uuid.New(), PasteID: paste.ID}
+		revision.BlobKey = models.RevisionKey(paste.ID, revision.ID)
+		paste.BlobKey = revision.BlobKey
+
+		err = store.Pastes.CreatePaste(paste, revision, &models.PasteAccess{
+			ID: access.ID, PasteID: paste.ID, UserID: owner.ID, Role: models.RoleOwner,
+		})
+		if err == nil {
+			t.Fatal("CreatePaste reused a grant's ID")
+		}
+
+		_, err = store.Pastes.GetPasteByID(paste.ID)
+		if err == nil {
+			t.Error("the paste was kept after its grant failed")
+		}
+
+		revisions, err := store.Pastes.GetPasteRevisionsByPasteId(paste.ID)
+		if err != nil || len(revisions) != 0 {
+			t.Errorf("revisions after a failed create are %v, %v", revisions, err)
+		}
+	})
+}
+
+func TestDeletePasteSchedulesItsBlobs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		owner := createTestUser(t, store, "owner@example.com")
+		paste := createTestPaste(t, store, owner, models.Paste{})
+		second := commitTestRevision(t, store, paste)
+
+		kept := createTestPaste(t, store, owner, models.Paste{})
+
+		keys, err := store.Pastes.DeletePaste(paste)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(keys)
+
+		want := []string{paste.BlobKey, second.BlobKey}
+		slices.Sort(want)
+
+		if !slices.Equal(keys, want) {
+			t.Errorf("DeletePaste returned %v, want the keys of both revisions %v", keys, want)
+		}
+
+		deletions, err := store.Pastes.GetBlobDeletions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scheduled := []string{}
+		for _, deletion := range deletions {
+			scheduled = append(scheduled, deletion.Key)
+		}
+
+		slices.Sort(scheduled)
+
+		if !slices.Equal(scheduled, want) {
+			t.Errorf("scheduled deletions are %v, want %v", scheduled, want)
+		}
+
+		_, err = store.Pastes.GetPasteByID(paste.ID)
+		if err == nil {
+			t.Error("the paste is still there")
+		}
+
+		_, err = store.Access.GetPasteAccessRecordByUserIdAndPasteId(owner.ID, paste.ID)
+		if err == nil {
+			t.Error("the owner's grant is still there")
+		}
+
+		revisions, err := store.Pastes.GetPasteRevisionsByPasteId(paste.ID)
+		if err != nil || len(revisions) != 0 {
+			t.Errorf("revisions after the delete are %v, %v", revisions, err)
+		}
+
+		_, err = store.Pastes.GetPasteByID(kept.ID)
+		if err != nil {
+			t.Errorf("another paste was deleted too: %v", err)
+		}
+	})
+}
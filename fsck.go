@@ -0,0 +1,370 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/internal/reaper"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+const (
+	fsckBatchSize     = 500
+	fsckProgressEvery = 1000
+)
+
+// fsckReport is what a consistency check found.
+type fsckReport struct {
+	// Blobs that no paste or revision uses and that aren't scheduled for
+	// deletion.
+	orphanedBlobs []string
+	// Pastes whose current content is missing from storage.
+	brokenPastes []models.Paste
+	// Revisions whose content is missing from storage, or whose paste no
+	// longer exists.
+	brokenRevisions []models.PasteRevision
+	// Grants and share links of pastes, users or teams that no longer
+	// exist.
+	danglingAccesses     []models.PasteAccess
+	danglingTeamAccesses []models.TeamPasteAccess
+	danglingShareLinks   []models.ShareLink
+}
+
+// checkConsistency compares storage with the database and reports what
+// doesn't match. With -repair it deletes orphaned blobs, pastes and
+// revisions whose content is gone, and dangling grants and share links, and
+// -dry-run lists those repairs without making them. It never migrates the
+// database, and refuses to run until pending migrations are applied. Only
+// the SQL backend has anything to check: the in-memory one starts empty, so
+// every blob would look orphaned.
+func checkConsistency(args []string) error {
+	backend := os.Getenv("DATABASE_BACKEND")
+	if backend != "" && backend != "sql" {
+		return fmt.Errorf("the %q database backend has nothing to check", backend)
+	}
+
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix the problems found")
+	dryRun := flags.Bool("dry-run", false, "list the repairs without making them")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	store, err := database.Connect()
+	if err != nil {
+		return err
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	report, err := findInconsistencies(store, storage)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Found %d orphaned blobs, %d pastes and %d revisions with missing content, "+
+			"%d dangling paste accesses, %d dangling team paste accesses "+
+			"and %d dangling share links",
+		len(report.orphanedBlobs), len(report.brokenPastes),
+		len(report.brokenRevisions), len(report.danglingAccesses),
+		len(report.danglingTeamAccesses), len(report.danglingShareLinks),
+	)
+
+	if !*repair {
+		return nil
+	}
+
+	return repairInconsistencies(store, storage, report, *dryRun)
+}
+
+func findInconsistencies(
+	store *database.Store, storage fileupload.Storage,
+) (*fsckReport, error) {
+	report := &fsckReport{}
+
+	// Records changed after this may point at blobs uploaded after they
+	// were listed, so they aren't checked.
+	started := time.Now()
+
+	blobs := map[string]bool{}
+
+	err := storage.Walk(func(key string) error {
+		blobs[key] = true
+
+		if len(blobs)%fsckProgressEvery == 0 {
+			log.Printf("Listed %d blobs", len(blobs))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Listed %d blobs", len(blobs))
+
+	// Scheduled blobs are uploads in progress or deletions yet to happen.
+	deletions, err := store.Pastes.GetBlobDeletions()
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for _, deletion := range deletions {
+		used[deletion.Key] = true
+	}
+
+	currentKeys := map[string]string{}
+
+	err = store.Pastes.WalkPastes(fsckBatchSize, func(paste *models.Paste) error {
+		key := paste.StorageKey()
+
+		currentKeys[paste.ID] = key
+		used[key] = true
+
+		if !blobs[key] && paste.UpdatedAt.Before(started) {
+			log.Printf("Paste %s: blob %q is missing", paste.ID, key)
+
+			report.brokenPastes = append(report.brokenPastes, *paste)
+		}
+
+		if len(currentKeys)%fsckProgressEvery == 0 {
+			log.Printf("Checked %d pastes", len(currentKeys))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Checked %d pastes", len(currentKeys))
+
+	checked := 0
+
+	err = store.Pastes.WalkPasteRevisions(fsckBatchSize, func(
+		revision *models.PasteRevision,
+	) error {
+		checked++
+
+		if checked%fsckProgressEvery == 0 {
+			log.Printf("Checked %d revisions", checked)
+		}
+
+		currentKey, found := currentKeys[revision.PasteID]
+
+		switch {
+		case !found && revision.CreatedAt.Before(started):
+			log.Printf(
+				"Revision %d of paste %s: the paste no longer exists",
+				revision.Number, revision.PasteID,
+			)
+		case !found:
+			// Committed since the pastes were checked.
+			return nil
+		case blobs[revision.BlobKey] || !revision.CreatedAt.Before(started):
+			used[revision.BlobKey] = true
+
+			return nil
+		case revision.BlobKey == currentKey:
+			// Repaired with its paste.
+			return nil
+		default:
+			log.Printf(
+				"Revision %d of paste %s: blob %q is missing",
+				revision.Number, revision.PasteID, revision.BlobKey,
+			)
+		}
+
+		report.brokenRevisions = append(report.brokenRevisions, *revision)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Checked %d revisions", checked)
+
+	for key := range blobs {
+		if used[key] {
+			continue
+		}
+
+		// The walks can miss pastes committed while they ran, so unused
+		// blobs are checked against their paste once more.
+		orphaned, err := isOrphanedBlob(store, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if orphaned {
+			report.orphanedBlobs = append(report.orphanedBlobs, key)
+		}
+	}
+
+	sort.Strings(report.orphanedBlobs)
+
+	for _, key := range report.orphanedBlobs {
+		log.Printf("Blob %q is not used by any paste", key)
+	}
+
+	report.danglingAccesses, err = store.Access.GetDanglingPasteAccessRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, access := range report.danglingAccesses {
+		log.Printf(
+			"Paste access %s: paste %s or user %s no longer exists",
+			access.ID, access.PasteID, access.UserID,
+		)
+	}
+
+	report.danglingTeamAccesses, err = store.Access.GetDanglingTeamPasteAccessRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, access := range report.danglingTeamAccesses {
+		log.Printf(
+			"Team paste access %s: paste %s or team %s no longer exists",
+			access.ID, access.PasteID, access.TeamID,
+		)
+	}
+
+	report.danglingShareLinks, err = store.Access.GetDanglingShareLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range report.danglingShareLinks {
+		log.Printf("Share link %s: paste %s no longer exists", link.ID, link.PasteID)
+	}
+
+	return report, nil
+}
+
+func isOrphanedBlob(store *database.Store, key string) (bool, error) {
+	paste, err := store.Pastes.GetPasteByID(models.KeyPasteID(key))
+	if err == database.ErrNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	keys, err := store.Pastes.GetPasteBlobKeys(paste)
+	if err != nil {
+		return false, err
+	}
+
+	return !slices.Contains(keys, key), nil
+}
+
+func repairInconsistencies(
+	store *database.Store, storage fileupload.Storage,
+	report *fsckReport, dryRun bool,
+) error {
+	if dryRun {
+		for _, key := range report.orphanedBlobs {
+			log.Printf("Would delete blob %q", key)
+		}
+
+		for _, paste := range report.brokenPastes {
+			log.Printf("Would delete paste %s", paste.ID)
+		}
+
+		for _, revision := range report.brokenRevisions {
+			log.Printf("Would delete revision %d of paste %s", revision.Number, revision.PasteID)
+		}
+
+		for _, access := range report.danglingAccesses {
+			log.Printf("Would delete paste access %s", access.ID)
+		}
+
+		for _, access := range report.danglingTeamAccesses {
+			log.Printf("Would delete team paste access %s", access.ID)
+		}
+
+		for _, link := range report.danglingShareLinks {
+			log.Printf("Would delete share link %s", link.ID)
+		}
+
+		return nil
+	}
+
+	// Orphans go through the deletion schedule like any other blob, so one
+	// that can't be deleted now is retried by the reaper.
+	err := store.Pastes.ScheduleBlobDeletions(report.orphanedBlobs, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = reaper.DeleteBlobs(store.Pastes, storage, report.orphanedBlobs)
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, paste := range report.brokenPastes {
+		keys, err := store.Pastes.DeletePaste(&paste)
+		if err != nil {
+			return err
+		}
+
+		err = reaper.DeleteBlobs(store.Pastes, storage, keys)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	for _, revision := range report.brokenRevisions {
+		err = store.Pastes.DeletePasteRevisionRecord(&revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, access := range report.danglingAccesses {
+		err = store.Access.DeletePasteAccessRecord(&access)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, access := range report.danglingTeamAccesses {
+		err = store.Access.DeleteTeamPasteAccess(access.TeamID, access.PasteID)
+		if err != nil && err != database.ErrNotFound {
+			return err
+		}
+	}
+
+	for _, link := range report.danglingShareLinks {
+		err = store.Access.DeleteShareLinkRecord(&link)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf(
+		"Deleted %d blobs, %d pastes, %d revisions, %d paste accesses, "+
+			"%d team paste accesses and %d share links",
+		len(report.orphanedBlobs), len(report.brokenPastes),
+		len(report.brokenRevisions), len(report.danglingAccesses),
+		len(report.danglingTeamAccesses), len(report.danglingShareLinks),
+	)
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsckRefusesTheMemoryBackend(t *testing.T) {
+	dir := t.TempDir()
+
+	blob := filepath.Join(dir, "blob")
+
+	err := os.WriteFile(blob, []byte("content"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DATABASE_BACKEND", "memory")
+	t.Setenv("STORAGE_BACKEND", "local")
+	t.Setenv("STORAGE_PATH", dir)
+	t.Setenv("STORAGE_ENCRYPTION_KEY_FILE", "")
+
+	// The in-memory database is empty, so every blob would be orphaned.
+	err = checkConsistency([]string{"-repair"})
+	if err == nil {
+		t.Error("fsck -repair ran against the memory backend")
+	}
+
+	_, err = os.Stat(blob)
+	if err != nil {
+		t.Errorf("blob after fsck -repair: %v", err)
+	}
+}
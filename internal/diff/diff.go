@@ -0,0 +1,332 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// op is one line of an edit script, with the position it applies at in the
+// old text (a) and the new text (b).
+type op struct {
+	kind opKind
+	a    int
+	b    int
+}
+
+type lines struct {
+	text []string
+	// Whether the last line is missing its trailing newline.
+	noEOL bool
+}
+
+func splitLines(text string) lines {
+	if text == "" {
+		return lines{}
+	}
+
+	split := strings.SplitAfter(text, "\n")
+	if split[len(split)-1] == "" {
+		split = split[:len(split)-1]
+	}
+
+	return lines{
+		text:  split,
+		noEOL: !strings.HasSuffix(text, "\n"),
+	}
+}
+
+// maxCost bounds the work myers does on the lines left between the common
+// prefix and suffix: each diagonal it visits, each matching line it skips and
+// each entry of the trace it keeps counts once. As the trace alone costs d*d
+// by round d, the edit distance never gets past maxEdits. Texts that would
+// cost more aren't diffed line by line.
+const (
+	maxEdits = 1 << 11
+	maxCost  = maxEdits * maxEdits
+)
+
+// Unified returns a unified diff of two texts with the given number of
+// context lines, or "" if they are equal. Texts too different to diff within
+// maxCost get a single "Files ... differ" line instead, as diff does for
+// binary files.
+func Unified(fromName string, toName string, from string, to string, context int) string {
+	if from == to {
+		return ""
+	}
+
+	a := splitLines(from)
+	b := splitLines(to)
+
+	ops, ok := diffLines(a.text, b.text)
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ\n", fromName, toName)
+	}
+
+	var out strings.Builder
+
+	for _, hunk := range hunks(ops, context) {
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		writeHunk(&out, ops[hunk[0]:hunk[1]], a, b)
+	}
+
+	return out.String()
+}
+
+// diffLines computes an edit script between a and b, leaving their common
+// prefix and suffix out of myers. It reports false if that would cost more
+// than maxCost.
+func diffLines(a []string, b []string) ([]op, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	middle, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		return nil, false
+	}
+
+	ops := make([]op, 0, prefix+len(middle)+suffix)
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{kind: opEqual, a: i, b: i})
+	}
+
+	for _, o := range middle {
+		ops = append(ops, op{kind: o.kind, a: o.a + prefix, b: o.b + prefix})
+	}
+
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, op{kind: opEqual, a: len(a) - i, b: len(b) - i})
+	}
+
+	return ops, true
+}
+
+// myers computes a shortest edit script between a and b using Myers'
+// algorithm. Each round only records the diagonals it can reach, so the
+// trace grows with the square of the edit distance; it gives up, reporting
+// false, once the work done passes maxCost.
+func myers(a []string, b []string) ([]op, bool) {
+	n, m := len(a), len(b)
+
+	max := n + m
+	if max > maxEdits+1 {
+		max = maxEdits + 1
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	cost := 0
+
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		cost += 2*d + 1
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+				cost++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, n, m), true
+			}
+
+			cost++
+		}
+
+		if cost > maxCost {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+func backtrack(trace [][]int, n int, m int) []op {
+	var ops []op
+
+	x, y := n, m
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{kind: opEqual, a: x, b: y})
+		}
+
+		if x == prevX {
+			ops = append(ops, op{kind: opInsert, a: prevX, b: prevY})
+		} else {
+			ops = append(ops, op{kind: opDelete, a: prevX, b: prevY})
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, op{kind: opEqual, a: x, b: y})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// hunks groups changes separated by no more than 2*context equal lines,
+// returning [start, end) ranges into ops that include the context.
+func hunks(ops []op, context int) [][2]int {
+	var result [][2]int
+
+	i := 0
+
+	for i < len(ops) {
+		if ops[i].kind == opEqual {
+			i++
+
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		if len(result) > 0 && start < result[len(result)-1][1] {
+			start = result[len(result)-1][1]
+		}
+
+		end := i
+
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+
+				continue
+			}
+
+			run := end
+			for run < len(ops) && ops[run].kind == opEqual {
+				run++
+			}
+
+			if run == len(ops) || run-end > 2*context {
+				break
+			}
+
+			end = run
+		}
+
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		result = append(result, [2]int{start, stop})
+		i = stop
+	}
+
+	return result
+}
+
+func hunkRange(start int, count int) string {
+	// An empty range names the line before it, so it isn't shifted to 1-based.
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeHunk(out *strings.Builder, ops []op, a lines, b lines) {
+	fromCount, toCount := 0, 0
+
+	for _, o := range ops {
+		if o.kind != opInsert {
+			fromCount++
+		}
+
+		if o.kind != opDelete {
+			toCount++
+		}
+	}
+
+	fmt.Fprintf(
+		out, "@@ -%s +%s @@\n",
+		hunkRange(ops[0].a, fromCount), hunkRange(ops[0].b, toCount),
+	)
+
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			writeLine(out, ' ', a, o.a)
+		case opDelete:
+			writeLine(out, '-', a, o.a)
+		case opInsert:
+			writeLine(out, '+', b, o.b)
+		}
+	}
+}
+
+func writeLine(out *strings.Builder, prefix byte, text lines, index int) {
+	out.WriteByte(prefix)
+	out.WriteString(text.text[index])
+
+	if text.noEOL && index == len(text.text)-1 {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
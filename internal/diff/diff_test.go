@@ -0,0 +1,143 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedLines(from int, to int) string {
+	var text strings.Builder
+
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&text, "line %d\n", i)
+	}
+
+	return text.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "insertion",
+			from: "a\nc\n",
+			to:   "a\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+		{
+			name: "deletion",
+			from: "a\nb\nc\n",
+			to:   "a\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			name: "into empty",
+			from: "a\n",
+			to:   "",
+			want: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "missing newline",
+			from: "a\n",
+			to:   "a",
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "context",
+			from: numberedLines(1, 10),
+			to:   strings.Replace(numberedLines(1, 10), "line 5\n", "line five\n", 1),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n" +
+				" line 2\n line 3\n line 4\n-line 5\n+line five\n line 6\n line 7\n line 8\n",
+		},
+	}
+
+	for _, test := range tests {
+		got := Unified("old", "new", test.from, test.to, 3)
+		if got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	from := numberedLines(1, 30)
+
+	// Changes more than twice the context apart get their own hunks.
+	apart := strings.Replace(from, "line 3\n", "", 1)
+	apart = strings.Replace(apart, "line 25\n", "line 25\nextra\n", 1)
+
+	got := Unified("old", "new", from, apart, 3)
+	if strings.Count(got, "@@ -") != 2 ||
+		!strings.Contains(got, "@@ -1,6 +1,5 @@\n") ||
+		!strings.Contains(got, "@@ -23,6 +22,7 @@\n") {
+		t.Errorf("changes far apart:\n%s", got)
+	}
+
+	// Changes closer than that share one.
+	near := strings.Replace(from, "line 3\n", "", 1)
+	near = strings.Replace(near, "line 8\n", "line 8\nextra\n", 1)
+
+	got = Unified("old", "new", from, near, 3)
+	if strings.Count(got, "@@ -") != 1 || !strings.Contains(got, "@@ -1,11 +1,11 @@\n") {
+		t.Errorf("changes close together:\n%s", got)
+	}
+}
+
+func TestUnifiedGivesUpOnLargeChanges(t *testing.T) {
+	// Fully different texts of 2 MiB each used to need memory growing with
+	// the square of their line count.
+	from := strings.Repeat("a\n", 1<<20)
+	to := strings.Repeat("b\n", 1<<20)
+
+	got := Unified("old", "new", from, to, 3)
+	if got != "Files old and new differ\n" {
+		t.Errorf("got %.100q, want the files to be reported as different", got)
+	}
+
+	// A small change in a large text is still diffed, whatever its size.
+	changed := strings.Replace(numberedLines(1, 100000), "line 50000\n", "changed\n", 1)
+
+	got = Unified("old", "new", numberedLines(1, 100000), changed, 0)
+	if got != "--- old\n+++ new\n@@ -50000 +50000 @@\n-line 50000\n+changed\n" {
+		t.Errorf("small change in a large text:\n%s", got)
+	}
+}
+
+func TestMyersStaysWithinBudget(t *testing.T) {
+	different := func(lines int) ([]string, []string) {
+		a := make([]string, lines)
+		b := make([]string, lines)
+
+		for i := range a {
+			a[i] = fmt.Sprintf("a%d\n", i)
+			b[i] = fmt.Sprintf("b%d\n", i)
+		}
+
+		return a, b
+	}
+
+	a, b := different(maxEdits / 4)
+
+	ops, ok := myers(a, b)
+	if !ok || len(ops) != len(a)+len(b) {
+		t.Errorf("%d edits: got %d ops, %v", len(a)+len(b), len(ops), ok)
+	}
+
+	a, b = different(maxEdits/2 + 1)
+
+	_, ok = myers(a, b)
+	if ok {
+		t.Errorf("%d edits are more than maxEdits", len(a)+len(b))
+	}
+}
@@ -0,0 +1,438 @@
+package fileupload
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted blobs start with a header naming the master key and holding the
+// wrapped data key, followed by the content sealed with AES-256-GCM in
+// fixed-size chunks so it can be streamed and read in ranges. Each chunk's
+// nonce is its index, with a flag on the last chunk so truncation is caught.
+const (
+	blobMagic        = "PSTYENC1"
+	blobChunkSize    = 64 << 10
+	blobTagSize      = 16
+	blobSealedSize   = blobChunkSize + blobTagSize
+	maxWrappedKeyLen = 1024
+	maxBlobHeaderLen = len(blobMagic) + 1 + 255 + 2 + maxWrappedKeyLen
+)
+
+var errInvalidBlob = errors.New("invalid encrypted blob")
+
+// EncryptedStorage encrypts blobs at rest with envelope encryption: every
+// blob gets its own data key, stored alongside it wrapped by a master key.
+// Blobs stored before encryption was turned on are still read as they are.
+type EncryptedStorage struct {
+	Storage Storage
+	Keys    KeyWrapper
+}
+
+type blobHeader struct {
+	keyId   string
+	wrapped []byte
+}
+
+func (h *blobHeader) encode() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(blobMagic)
+	buf.WriteByte(byte(len(h.keyId)))
+	buf.WriteString(h.keyId)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.wrapped)))
+	buf.Write(h.wrapped)
+
+	return buf.Bytes()
+}
+
+func (h *blobHeader) size() int64 {
+	return int64(len(blobMagic) + 1 + len(h.keyId) + 2 + len(h.wrapped))
+}
+
+// readBlobHeader reads the header of an encrypted blob, returning nil if the
+// blob isn't encrypted.
+func readBlobHeader(r *bufio.Reader) (*blobHeader, error) {
+	magic, err := r.Peek(len(blobMagic))
+	if err == io.EOF || (err == nil && string(magic) != blobMagic) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	r.Discard(len(blobMagic))
+
+	idLen, err := r.ReadByte()
+	if err != nil {
+		return nil, errInvalidBlob
+	}
+
+	id := make([]byte, idLen)
+
+	_, err = io.ReadFull(r, id)
+	if err != nil {
+		return nil, errInvalidBlob
+	}
+
+	var wrappedLen uint16
+
+	err = binary.Read(r, binary.BigEndian, &wrappedLen)
+	if err != nil || wrappedLen > maxWrappedKeyLen {
+		return nil, errInvalidBlob
+	}
+
+	wrapped := make([]byte, wrappedLen)
+
+	_, err = io.ReadFull(r, wrapped)
+	if err != nil {
+		return nil, errInvalidBlob
+	}
+
+	return &blobHeader{keyId: string(id), wrapped: wrapped}, nil
+}
+
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+
+	return nonce
+}
+
+// plaintextSize is the size of the content sealed in body bytes of chunks.
+func plaintextSize(body int64) int64 {
+	chunks := (body + blobSealedSize - 1) / blobSealedSize
+
+	return body - chunks*blobTagSize
+}
+
+func (s *EncryptedStorage) openDataKey(header *blobHeader) (cipher.AEAD, error) {
+	dataKey, err := s.Keys.Unwrap(header.keyId, header.wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAEAD(dataKey)
+}
+
+func (s *EncryptedStorage) Put(key string, body io.Reader) error {
+	dataKey := make([]byte, 32)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := s.Keys.Wrap(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := &blobHeader{keyId: s.Keys.KeyID(), wrapped: wrapped}
+
+	return s.Storage.Put(key, io.MultiReader(
+		bytes.NewReader(header.encode()),
+		&encryptReader{
+			aead:  aead,
+			src:   bufio.NewReader(body),
+			plain: make([]byte, blobChunkSize),
+			out:   make([]byte, blobSealedSize),
+		},
+	))
+}
+
+func (s *EncryptedStorage) Get(key string) (io.ReadCloser, int64, error) {
+	body, size, err := s.Storage.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader := bufio.NewReader(body)
+
+	header, err := readBlobHeader(reader)
+	if err != nil {
+		body.Close()
+
+		return nil, 0, err
+	}
+
+	if header == nil {
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, body}, size, nil
+	}
+
+	aead, err := s.openDataKey(header)
+	if err != nil {
+		body.Close()
+
+		return nil, 0, err
+	}
+
+	decrypted := newDecryptReader(aead, reader, 0, true)
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{decrypted, body}, plaintextSize(size - header.size()), nil
+}
+
+// GetRange only fetches and decrypts the chunks covering the range.
+func (s *EncryptedStorage) GetRange(key string, offset int64, length int64) (
+	io.ReadCloser, error,
+) {
+	head, err := s.Storage.GetRange(key, 0, int64(maxBlobHeaderLen))
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := readBlobHeader(bufio.NewReader(head))
+	head.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		return s.Storage.GetRange(key, offset, length)
+	}
+
+	if length <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	aead, err := s.openDataKey(header)
+	if err != nil {
+		return nil, err
+	}
+
+	first := offset / blobChunkSize
+	last := (offset + length - 1) / blobChunkSize
+
+	body, err := s.Storage.GetRange(
+		key, header.size()+first*blobSealedSize, (last-first+1)*blobSealedSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := newDecryptReader(aead, bufio.NewReader(body), first, false)
+
+	_, err = io.CopyN(io.Discard, reader, offset-first*blobChunkSize)
+	if err != nil {
+		body.Close()
+
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, length), body}, nil
+}
+
+func (s *EncryptedStorage) Delete(key string) error {
+	return s.Storage.Delete(key)
+}
+
+func (s *EncryptedStorage) Walk(fn func(key string) error) error {
+	return s.Storage.Walk(fn)
+}
+
+// Rekey rewraps the data key of a blob with the current master key, leaving
+// the content as it is, and encrypts blobs stored before encryption was
+// turned on. It reports whether the blob was rewritten.
+func (s *EncryptedStorage) Rekey(key string) (bool, error) {
+	body, _, err := s.Storage.Get(key)
+	if err != nil {
+		return false, err
+	}
+
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+
+	header, err := readBlobHeader(reader)
+	if err != nil {
+		return false, err
+	}
+
+	if header == nil {
+		return true, s.Put(key, reader)
+	}
+
+	if header.keyId == s.Keys.KeyID() {
+		return false, nil
+	}
+
+	dataKey, err := s.Keys.Unwrap(header.keyId, header.wrapped)
+	if err != nil {
+		return false, err
+	}
+
+	wrapped, err := s.Keys.Wrap(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	rewrapped := &blobHeader{keyId: s.Keys.KeyID(), wrapped: wrapped}
+
+	return true, s.Storage.Put(
+		key, io.MultiReader(bytes.NewReader(rewrapped.encode()), reader),
+	)
+}
+
+type encryptReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	index int64
+	plain []byte
+	out   []byte
+	// Sealed bytes not read yet.
+	sealed []byte
+	done   bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.sealed) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(r.src, r.plain)
+
+		final := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			final = true
+		} else if err != nil {
+			return 0, err
+		} else {
+			_, err = r.src.Peek(1)
+			if err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		r.sealed = r.aead.Seal(
+			r.out[:0], chunkNonce(r.index, final), r.plain[:n], nil,
+		)
+		r.index++
+		r.done = final
+	}
+
+	n := copy(p, r.sealed)
+	r.sealed = r.sealed[n:]
+
+	return n, nil
+}
+
+type decryptReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	index  int64
+	sealed []byte
+	out    []byte
+	// Decrypted bytes not read yet.
+	plain []byte
+	// A whole blob must end with the final chunk. A range may end anywhere,
+	// and which of its chunks is the final one isn't known up front.
+	whole bool
+	done  bool
+}
+
+func newDecryptReader(
+	aead cipher.AEAD, src *bufio.Reader, index int64, whole bool,
+) *decryptReader {
+	return &decryptReader{
+		aead:   aead,
+		src:    src,
+		index:  index,
+		sealed: make([]byte, blobSealedSize),
+		out:    make([]byte, blobChunkSize),
+		whole:  whole,
+	}
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err == io.EOF {
+		if r.whole {
+			return errInvalidBlob
+		}
+
+		r.done = true
+
+		return nil
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	end := err == io.ErrUnexpectedEOF
+	if !end {
+		_, err = r.src.Peek(1)
+		if err == io.EOF {
+			end = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	sealed := r.sealed[:n]
+
+	var plain []byte
+
+	if r.whole {
+		plain, err = r.aead.Open(r.out[:0], chunkNonce(r.index, end), sealed, nil)
+		r.done = end
+	} else {
+		plain, err = r.aead.Open(r.out[:0], chunkNonce(r.index, false), sealed, nil)
+		if err != nil {
+			plain, err = r.aead.Open(
+				r.out[:0], chunkNonce(r.index, true), sealed, nil,
+			)
+			r.done = true
+		}
+	}
+
+	if err != nil {
+		return errInvalidBlob
+	}
+
+	r.plain = plain
+	r.index++
+
+	return nil
+}
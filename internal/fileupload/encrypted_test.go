@@ -0,0 +1,331 @@
+package fileupload
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testContent returns size bytes that differ from chunk to chunk, so
+// reordered or misplaced chunks don't read back the same.
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	return content
+}
+
+func testKeys(ids ...string) *LocalKeyWrapper {
+	wrapper := &LocalKeyWrapper{current: ids[0], keys: map[string][]byte{}}
+
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		wrapper.keys[id] = key[:]
+	}
+
+	return wrapper
+}
+
+func newTestEncryptedStorage(t *testing.T) (*EncryptedStorage, *MemoryStorage) {
+	t.Helper()
+
+	raw := NewMemoryStorage()
+
+	return &EncryptedStorage{Storage: raw, Keys: testKeys("primary")}, raw
+}
+
+// getRaw returns the stored blob split into its header and sealed chunks.
+func getRaw(t *testing.T, raw Storage, key string) (*blobHeader, [][]byte) {
+	t.Helper()
+
+	body, _, err := raw.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+
+	header, err := readBlobHeader(reader)
+	if err != nil || header == nil {
+		t.Fatalf("blob %q has no header: %v", key, err)
+	}
+
+	sealed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	for len(sealed) > blobSealedSize {
+		chunks = append(chunks, sealed[:blobSealedSize])
+		sealed = sealed[blobSealedSize:]
+	}
+
+	return header, append(chunks, sealed)
+}
+
+func putRaw(t *testing.T, raw Storage, key string, header *blobHeader, chunks [][]byte) {
+	t.Helper()
+
+	blob := header.encode()
+	for _, chunk := range chunks {
+		blob = append(blob, chunk...)
+	}
+
+	err := raw.Put(key, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	store, raw := newTestEncryptedStorage(t)
+
+	for _, size := range []int{0, 1, blobChunkSize, blobChunkSize + 1} {
+		content := testContent(size)
+
+		err := store.Put("pastes/a", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, got, err := store.Get("pastes/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read := readAll(t, body); read != string(content) || got != int64(size) {
+			t.Errorf("%d bytes read back as %d, with size %d", size, len(read), got)
+		}
+
+		_, chunks := getRaw(t, raw, "pastes/a")
+
+		// Empty content still gets a final chunk, sealing nothing.
+		wantChunks := (size + blobChunkSize - 1) / blobChunkSize
+		if size == 0 {
+			wantChunks = 1
+		}
+
+		if len(chunks) != wantChunks {
+			t.Errorf("%d bytes were sealed in %d chunks, want %d", size, len(chunks), wantChunks)
+		}
+
+		if size > 0 && bytes.Contains(chunks[0], content) {
+			t.Errorf("%d bytes were stored in the clear", size)
+		}
+	}
+}
+
+func TestEncryptedStorageGetRange(t *testing.T) {
+	store, _ := newTestEncryptedStorage(t)
+
+	size := 3*blobChunkSize + 100
+	content := testContent(size)
+
+	err := store.Put("pastes/a", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		length int
+	}{
+		{"first chunk", 10, 20},
+		{"across one boundary", blobChunkSize - 10, 20},
+		{"across two boundaries", blobChunkSize - 1, blobChunkSize + 2},
+		{"a whole chunk", blobChunkSize, blobChunkSize},
+		{"into the final chunk", 3*blobChunkSize - 5, 10},
+		{"past the end", 3*blobChunkSize + 90, 100},
+		{"everything", 0, size},
+		{"nothing", 5, 0},
+	}
+
+	for _, test := range tests {
+		body, err := store.GetRange("pastes/a", int64(test.offset), int64(test.length))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+		}
+
+		end := test.offset + test.length
+		if end > size {
+			end = size
+		}
+
+		if got := readAll(t, body); got != string(content[test.offset:end]) {
+			t.Errorf("%s: got %d bytes that don't match the content", test.name, len(got))
+		}
+	}
+}
+
+func TestEncryptedStorageRejectsTamperedBlobs(t *testing.T) {
+	store, raw := newTestEncryptedStorage(t)
+
+	err := store.Put("pastes/a", bytes.NewReader(testContent(2*blobChunkSize+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, chunks := getRaw(t, raw, "pastes/a")
+
+	dataKey, err := store.Keys.Unwrap(header.keyId, header.wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reseal seals chunk i again with its final flag set to final, as only
+	// someone holding the data key could.
+	reseal := func(i int, final bool) []byte {
+		plain, err := aead.Open(nil, chunkNonce(int64(i), i == len(chunks)-1), chunks[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return aead.Seal(nil, chunkNonce(int64(i), final), plain, nil)
+	}
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{"without its final chunk", chunks[:2]},
+		{"cut inside a chunk", [][]byte{chunks[0], chunks[1][:100]}},
+		{"with chunks reordered", [][]byte{chunks[1], chunks[0], chunks[2]}},
+		{"with a chunk repeated", [][]byte{chunks[0], chunks[0], chunks[1], chunks[2]}},
+		{"with a flipped bit", [][]byte{
+			chunks[0], append([]byte{chunks[1][0] ^ 1}, chunks[1][1:]...), chunks[2],
+		}},
+		{"with the final flag cleared", [][]byte{chunks[0], chunks[1], reseal(2, false)}},
+		{"with the final flag set early", [][]byte{chunks[0], reseal(1, true), chunks[2]}},
+	}
+
+	for _, test := range tests {
+		putRaw(t, raw, "pastes/a", header, test.chunks)
+
+		body, _, err := store.Get("pastes/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = io.ReadAll(body)
+		body.Close()
+
+		if !errors.Is(err, errInvalidBlob) {
+			t.Errorf("reading a blob %s = %v, want errInvalidBlob", test.name, err)
+		}
+	}
+
+	// A range can't tell a missing final chunk from one it didn't ask for,
+	// but still rejects chunks out of place.
+	putRaw(t, raw, "pastes/a", header, [][]byte{chunks[1], chunks[0], chunks[2]})
+
+	body, err := store.GetRange("pastes/a", 10, 10)
+	if err == nil {
+		_, err = io.ReadAll(body)
+		body.Close()
+	}
+
+	if !errors.Is(err, errInvalidBlob) {
+		t.Errorf("reading a range of reordered chunks = %v, want errInvalidBlob", err)
+	}
+}
+
+func TestEncryptedStorageReadsPlaintextBlobs(t *testing.T) {
+	store, raw := newTestEncryptedStorage(t)
+
+	err := raw.Put("pastes/a", bytes.NewReader([]byte("stored before encryption")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, size, err := store.Get("pastes/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, body); got != "stored before encryption" || size != 24 {
+		t.Errorf("Get = %q (%d bytes)", got, size)
+	}
+
+	body, err = store.GetRange("pastes/a", 7, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, body); got != "before" {
+		t.Errorf("GetRange = %q, want %q", got, "before")
+	}
+}
+
+func TestEncryptedStorageRekey(t *testing.T) {
+	raw := NewMemoryStorage()
+	old := &EncryptedStorage{Storage: raw, Keys: testKeys("old")}
+
+	content := testContent(blobChunkSize + 1)
+
+	err := old.Put("pastes/a", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = raw.Put("pastes/b", bytes.NewReader([]byte("plaintext")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, oldChunks := getRaw(t, raw, "pastes/a")
+
+	// The key file lists the new key first, keeping the old one to unwrap.
+	rotated := &EncryptedStorage{Storage: raw, Keys: testKeys("new", "old")}
+
+	for key, want := range map[string]string{"pastes/a": string(content), "pastes/b": "plaintext"} {
+		changed, err := rotated.Rekey(key)
+		if err != nil || !changed {
+			t.Fatalf("Rekey(%q) = %v, %v, want it rewritten", key, changed, err)
+		}
+
+		header, _ := getRaw(t, raw, key)
+		if header.keyId != "new" {
+			t.Errorf("%q is wrapped with %q after Rekey, want new", key, header.keyId)
+		}
+
+		// Once everything is rekeyed, the old key can go.
+		current := &EncryptedStorage{Storage: raw, Keys: testKeys("new")}
+
+		body, _, err := current.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, body); got != want {
+			t.Errorf("%q reads back %d bytes after Rekey that don't match", key, len(got))
+		}
+
+		changed, err = rotated.Rekey(key)
+		if err != nil || changed {
+			t.Errorf("second Rekey(%q) = %v, %v, want it left alone", key, changed, err)
+		}
+	}
+
+	// Rewrapping leaves the sealed content as it was.
+	_, chunks := getRaw(t, raw, "pastes/a")
+	if len(chunks) != len(oldChunks) || !bytes.Equal(chunks[0], oldChunks[0]) ||
+		!bytes.Equal(chunks[1], oldChunks[1]) {
+		t.Error("Rekey re-encrypted the content")
+	}
+}
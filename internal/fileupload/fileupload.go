@@ -1,66 +1,118 @@
 package fileupload
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
-	"os"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/joho/godotenv"
 )
 
-var BucketName string
-var Client *s3.Client
+var ErrNotFound = errors.New("file not found")
+
+type Storage interface {
+	Put(key string, body io.Reader) error
+	Get(key string) (io.ReadCloser, int64, error)
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(key string) error
+	// Walk calls fn with every stored key, stopping at the first error.
+	Walk(fn func(key string) error) error
+}
+
+type FileInfo struct {
+	Size   int64
+	SHA256 string
+}
+
+// Config selects the storage backend and how it is set up.
+type Config struct {
+	// Backend is s3 (the default), local or memory.
+	Backend    string
+	BucketName string
+	Path       string
+	// EncryptionKeyFile holds the master keys blobs are encrypted with. Blobs
+	// are stored unencrypted without one.
+	EncryptionKeyFile string
+}
 
-func init() {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+// Open builds the storage described by config.
+func Open(config Config) (Storage, error) {
+	store, err := NewStorage(config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	Client = s3.NewFromConfig(cfg)
+	if config.EncryptionKeyFile == "" {
+		return store, nil
+	}
 
-	err = godotenv.Load()
+	keys, err := LoadKeyFile(config.EncryptionKeyFile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("loading storage encryption keys: %w", err)
 	}
 
-	BucketName = os.Getenv("BUCKET_NAME")
+	return &EncryptedStorage{Storage: store, Keys: keys}, nil
 }
 
-func UploadFile(key string, file *multipart.File) error {
-	_, err := Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(BucketName),
-		Key:    aws.String(key),
-		Body:   *file,
-	})
+func NewStorage(config Config) (Storage, error) {
+	switch config.Backend {
+	case "", "s3":
+		return NewS3Storage(config.BucketName)
+	case "local":
+		return NewLocalStorage(config.Path)
+	case "memory":
+		return NewMemoryStorage(), nil
+	}
 
-	return err
+	return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
 }
 
-func GetFile(key string) (io.ReadCloser, int64, error) {
-	result, err := Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(BucketName),
-		Key:    aws.String(key),
-	})
+// UploadFile stores body under key, returning its size and SHA-256 digest as
+// computed while streaming.
+func UploadFile(store Storage, key string, body io.Reader) (*FileInfo, error) {
+	hash := sha256.New()
+	counter := &countingReader{reader: io.TeeReader(body, hash)}
+
+	err := store.Put(key, counter)
+	if err != nil {
+		log.Println(err)
+
+		return nil, err
+	}
+
+	return &FileInfo{
+		Size:   counter.count,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func GetFile(store Storage, key string) (io.ReadCloser, int64, error) {
+	body, size, err := store.Get(key)
 	if err != nil {
 		log.Println(err)
 
 		return nil, 0, err
 	}
 
-	return result.Body, *result.ContentLength, nil
+	return body, size, nil
+}
+
+func GetFileRange(store Storage, key string, offset int64, length int64) (
+	io.ReadCloser, error,
+) {
+	body, err := store.GetRange(key, offset, length)
+	if err != nil {
+		log.Println(err)
+
+		return nil, err
+	}
+
+	return body, nil
 }
 
-func DeleteFile(key string) error {
-	_, err := Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(BucketName),
-		Key:    aws.String(key),
-	})
+func DeleteFile(store Storage, key string) error {
+	err := store.Delete(key)
 	if err != nil {
 		log.Println(err)
 
@@ -69,3 +121,15 @@ func DeleteFile(key string) error {
 
 	return nil
 }
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
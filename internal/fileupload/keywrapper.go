@@ -0,0 +1,134 @@
+package fileupload
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyWrapper encrypts the data keys of blobs with a master key. It is the
+// same shape as a KMS Encrypt/Decrypt API, so a KMS can stand in for the
+// local key file.
+type KeyWrapper interface {
+	// KeyID names the master key that new data keys are wrapped with.
+	KeyID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped by the named master key, which may
+	// be an older one.
+	Unwrap(keyId string, wrapped []byte) ([]byte, error)
+}
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// LocalKeyWrapper wraps data keys with AES-256-GCM master keys read from a
+// file.
+type LocalKeyWrapper struct {
+	current string
+	keys    map[string][]byte
+}
+
+// LoadKeyFile reads master keys from a file with one "<id> <base64 key>"
+// line per key. The first key wraps new data keys; the rest are only used to
+// unwrap, until everything has been rekeyed.
+func LoadKeyFile(path string) (*LocalKeyWrapper, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	wrapper := &LocalKeyWrapper{keys: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) > 255 {
+			return nil, fmt.Errorf("%s: malformed key line", path)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf(
+				"%s: key %q is not 32 bytes of base64", path, fields[0],
+			)
+		}
+
+		if wrapper.current == "" {
+			wrapper.current = fields[0]
+		}
+
+		wrapper.keys[fields[0]] = key
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if wrapper.current == "" {
+		return nil, fmt.Errorf("%s holds no keys", path)
+	}
+
+	return wrapper, nil
+}
+
+func (w *LocalKeyWrapper) KeyID() string {
+	return w.current
+}
+
+func (w *LocalKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(w.keys[w.current])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(w.current)), nil
+}
+
+func (w *LocalKeyWrapper) Unwrap(keyId string, wrapped []byte) ([]byte, error) {
+	key, ok := w.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMasterKey, keyId)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errInvalidBlob
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, sealed, []byte(keyId))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
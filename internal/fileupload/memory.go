@@ -0,0 +1,97 @@
+package fileupload
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+)
+
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: map[string][]byte{}}
+}
+
+func (s *MemoryStorage) Put(key string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[key] = data
+
+	return nil
+}
+
+func (s *MemoryStorage) Get(key string) (io.ReadCloser, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.files[key]
+	if !ok {
+		return nil, 0, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (s *MemoryStorage) GetRange(key string, offset int64, length int64) (
+	io.ReadCloser, error,
+) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	end := offset + length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+
+	return io.NopCloser(bytes.NewReader(data[offset:end])), nil
+}
+
+func (s *MemoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, key)
+
+	return nil
+}
+
+func (s *MemoryStorage) Walk(fn func(key string) error) error {
+	s.mu.RLock()
+
+	keys := make([]string, 0, len(s.files))
+	for key := range s.files {
+		keys = append(keys, key)
+	}
+
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := fn(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package fileupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3Storage struct {
+	Client     *s3.Client
+	Uploader   *manager.Uploader
+	BucketName string
+}
+
+func NewS3Storage(bucketName string) (*S3Storage, error) {
+	if bucketName == "" {
+		return nil, errors.New("BUCKET_NAME is required for the s3 backend")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg)
+
+	return &S3Storage{
+		Client:     client,
+		Uploader:   manager.NewUploader(client),
+		BucketName: bucketName,
+	}, nil
+}
+
+// Put goes through the upload manager, which sends bodies of unknown length
+// as a multipart upload one part at a time instead of buffering them whole.
+func (s *S3Storage) Put(key string, body io.Reader) error {
+	_, err := s.Uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+
+	return err
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, int64, error) {
+	result, err := s.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, 0, ErrNotFound
+		}
+
+		return nil, 0, err
+	}
+
+	return result.Body, aws.ToInt64(result.ContentLength), nil
+}
+
+func (s *S3Storage) GetRange(key string, offset int64, length int64) (
+	io.ReadCloser, error,
+) {
+	result, err := s.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+		Range: aws.String(
+			fmt.Sprintf("bytes=%d-%d", offset, offset+length-1),
+		),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return result.Body, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	_, err := s.Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+func (s *S3Storage) Walk(fn func(key string) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.BucketName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			err = fn(aws.ToString(object.Key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
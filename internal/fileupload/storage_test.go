@@ -0,0 +1,290 @@
+package fileupload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func walkKeys(t *testing.T, store Storage) []string {
+	t.Helper()
+
+	keys := []string{}
+
+	err := store.Walk(func(key string) error {
+		keys = append(keys, key)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+// testStorage checks the behaviour every backend has to share.
+func testStorage(t *testing.T, store Storage) {
+	t.Run("PutGet", func(t *testing.T) {
+		err := store.Put("pastes/a", strings.NewReader("hello, world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, size, err := store.Get("pastes/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, body); got != "hello, world" || size != 12 {
+			t.Errorf("Get = %q (%d bytes), want %q (12 bytes)", got, size, "hello, world")
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		err := store.Put("pastes/b", strings.NewReader("first"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Put("pastes/b", strings.NewReader("second"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _, err := store.Get("pastes/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, body); got != "second" {
+			t.Errorf("Get = %q, want %q", got, "second")
+		}
+	})
+
+	t.Run("GetRange", func(t *testing.T) {
+		err := store.Put("pastes/c", strings.NewReader("0123456789"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := store.GetRange("pastes/c", 3, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, body); got != "3456" {
+			t.Errorf("GetRange = %q, want %q", got, "3456")
+		}
+
+		body, err = store.GetRange("pastes/c", 8, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, body); got != "89" {
+			t.Errorf("GetRange past the end = %q, want %q", got, "89")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, _, err := store.Get("pastes/missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get = %v, want ErrNotFound", err)
+		}
+
+		_, err = store.GetRange("pastes/missing", 0, 1)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetRange = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		err := store.Put("pastes/d", strings.NewReader("gone soon"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Delete("pastes/d")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = store.Get("pastes/d")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+		}
+
+		// Deleting twice is not an error, so deletions can be retried.
+		err = store.Delete("pastes/d")
+		if err != nil {
+			t.Errorf("second Delete = %v", err)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		want := []string{"pastes/a", "pastes/b", "pastes/c"}
+		if got := walkKeys(t, store); !slices.Equal(got, want) {
+			t.Errorf("Walk = %v, want %v", got, want)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+
+		err := store.Walk(func(key string) error {
+			calls++
+
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("Walk returned %v after %d calls, want stop after 1", err, calls)
+		}
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemoryStorage())
+}
+
+func TestLocalStorage(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, store)
+}
+
+func TestLocalStorageRequiresPath(t *testing.T) {
+	_, err := NewLocalStorage("")
+	if err == nil {
+		t.Error("NewLocalStorage accepted an empty path")
+	}
+}
+
+func TestLocalStorageRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+
+	store, err := NewLocalStorage(filepath.Join(root, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../outside", "pastes/../../outside", "/etc/passwd"} {
+		err := store.Put(key, strings.NewReader("x"))
+		if err == nil {
+			t.Errorf("Put(%q) was accepted", key)
+		}
+	}
+
+	_, err = os.Stat(filepath.Join(root, "outside"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a blob was written outside the root: %v", err)
+	}
+}
+
+func TestLocalStorageWalkSkipsPartialUploads(t *testing.T) {
+	root := t.TempDir()
+
+	store, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put("pastes/a", strings.NewReader("done"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(root, "pastes", ".upload-123"), []byte("par"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := walkKeys(t, store); !slices.Equal(got, []string{"pastes/a"}) {
+		t.Errorf("Walk = %v, want [pastes/a]", got)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	store := NewMemoryStorage()
+	content := "some paste content"
+
+	info, err := UploadFile(store, "pastes/a", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	if info.Size != int64(len(content)) || info.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("UploadFile = %+v, want %d bytes hashing to %x", info, len(content), sum)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	store, err := Open(Config{Backend: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.(*MemoryStorage); !ok {
+		t.Errorf("Open(memory) = %T, want *MemoryStorage", store)
+	}
+
+	_, err = Open(Config{Backend: "floppy"})
+	if err == nil {
+		t.Error("Open accepted an unknown backend")
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+	err = os.WriteFile(keyFile, []byte("primary "+key+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = Open(Config{
+		Backend:           "local",
+		Path:              t.TempDir(),
+		EncryptionKeyFile: keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, ok := store.(*EncryptedStorage)
+	if !ok {
+		t.Fatalf("Open with a key file = %T, want *EncryptedStorage", store)
+	}
+
+	if _, ok := encrypted.Storage.(*LocalStorage); !ok {
+		t.Errorf("encrypted backend = %T, want *LocalStorage", encrypted.Storage)
+	}
+
+	testStorage(t, store)
+}
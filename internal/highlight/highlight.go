@@ -0,0 +1,202 @@
+package highlight
+
+import (
+	"html/template"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const PlainText = "plaintext"
+
+// Content types that browsers would execute or render as documents are
+// served as plain text instead.
+var unsafeContentTypes = map[string]bool{
+	"text/html":             true,
+	"text/xml":              true,
+	"text/javascript":       true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+}
+
+var page = template.Must(template.New("paste").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; font-family: sans-serif; }
+header { padding: 0.75em 1em; border-bottom: 1px solid #ddd; }
+header span { color: #666; margin-left: 1em; }
+{{.CSS}}
+</style>
+</head>
+<body>
+<header><strong>{{.Title}}</strong><span>{{.Language}}</span></header>
+{{.Code}}
+</body>
+</html>
+`))
+
+func canonicalName(lexer chroma.Lexer) string {
+	config := lexer.Config()
+	if len(config.Aliases) > 0 {
+		return config.Aliases[0]
+	}
+
+	return strings.ToLower(config.Name)
+}
+
+// Normalize resolves a user supplied language name or alias to the name
+// stored on pastes, reporting whether it is known.
+func Normalize(language string) (string, bool) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return "", false
+	}
+
+	return canonicalName(lexer), true
+}
+
+// Detect guesses a language from the uploaded file name, falling back to
+// analysing the first bytes of content. It returns "" if neither matches.
+func Detect(filename string, head []byte) string {
+	if filename != "" {
+		lexer := lexers.Match(filename)
+		if lexer != nil {
+			return canonicalName(lexer)
+		}
+	}
+
+	if len(head) == 0 || !IsText(head) {
+		return ""
+	}
+
+	lexer := shebangLexer(head)
+	if lexer == nil {
+		lexer = lexers.Analyse(string(head))
+	}
+
+	if lexer != nil {
+		return canonicalName(lexer)
+	}
+
+	return ""
+}
+
+// shebangLexer picks a lexer from a "#!" interpreter line, such as
+// "#!/bin/bash" or "#!/usr/bin/env python3".
+func shebangLexer(head []byte) chroma.Lexer {
+	line, found := strings.CutPrefix(string(head), "#!")
+	if !found {
+		return nil
+	}
+
+	line, _, _ = strings.Cut(line, "\n")
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+
+	lexer := lexers.Get(interpreter)
+	if lexer == nil {
+		lexer = lexers.Get(strings.TrimRight(interpreter, "0123456789."))
+	}
+
+	return lexer
+}
+
+// IsText reports whether head looks like UTF-8 text. A multi-byte rune cut
+// off at the end of head is not counted against it.
+func IsText(head []byte) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return len(head) < utf8.UTFMax && !utf8.FullRune(head)
+		}
+
+		if r == 0 {
+			return false
+		}
+
+		head = head[size:]
+	}
+
+	return true
+}
+
+// ContentType returns the Content-Type used to serve a paste raw.
+func ContentType(language string, head []byte) string {
+	if !IsText(head) {
+		return "application/octet-stream"
+	}
+
+	contentType := "text/plain"
+
+	lexer := lexers.Get(language)
+	if lexer != nil && len(lexer.Config().MimeTypes) > 0 {
+		mimeType := lexer.Config().MimeTypes[0]
+		if !unsafeContentTypes[mimeType] &&
+			(strings.HasPrefix(mimeType, "text/") ||
+				mimeType == "application/json") {
+			contentType = mimeType
+		}
+	}
+
+	return contentType + "; charset=utf-8"
+}
+
+// Render writes a standalone HTML page showing source highlighted as
+// language, with linkable line numbers.
+func Render(w io.Writer, title string, language string, source string) error {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+		language = PlainText
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, source)
+	if err != nil {
+		return err
+	}
+
+	style := styles.Get("github")
+	formatter := html.New(
+		html.WithClasses(true),
+		html.WithLineNumbers(true),
+		html.WithLinkableLineNumbers(true, "L"),
+	)
+
+	var css strings.Builder
+
+	err = formatter.WriteCSS(&css, style)
+	if err != nil {
+		return err
+	}
+
+	var code strings.Builder
+
+	err = formatter.Format(&code, style, iterator)
+	if err != nil {
+		return err
+	}
+
+	return page.Execute(w, map[string]interface{}{
+		"Title":    title,
+		"Language": language,
+		"CSS":      template.CSS(css.String()),
+		"Code":     template.HTML(code.String()),
+	})
+}
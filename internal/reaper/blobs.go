@@ -0,0 +1,79 @@
+package reaper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+)
+
+// Failed blob deletions are tried again after this long.
+const blobRetryDelay = 5 * time.Minute
+
+// DeleteBlobs deletes scheduled blobs from storage and forgets their
+// deletions. Blobs that can't be deleted stay scheduled and are retried
+// later; the errors are returned together.
+func DeleteBlobs(
+	pastes database.PasteStore, storage fileupload.Storage, keys []string,
+) error {
+	var errs []error
+
+	for _, key := range keys {
+		err := fileupload.DeleteFile(storage, key)
+		if err != nil && !errors.Is(err, fileupload.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("deleting blob %q: %w", key, err))
+
+			err = pastes.RetryBlobDeletion(
+				key, err.Error(), time.Now().Add(blobRetryDelay),
+			)
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+			continue
+		}
+
+		err = pastes.CompleteBlobDeletion(key)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DeleteDueBlobs deletes blobs whose deletion is due, in batches, until
+// none are left or a batch has failures.
+func DeleteDueBlobs(
+	pastes database.PasteStore, storage fileupload.Storage, batchSize int,
+) {
+	for {
+		deletions, err := pastes.GetDueBlobDeletions(time.Now(), batchSize)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+
+		keys := make([]string, len(deletions))
+		for i, deletion := range deletions {
+			keys[i] = deletion.Key
+		}
+
+		err = DeleteBlobs(pastes, storage, keys)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if len(deletions) > 0 {
+			log.Printf("Deleted %d scheduled blobs", len(deletions))
+		}
+
+		if err != nil || len(deletions) < batchSize {
+			return
+		}
+	}
+}
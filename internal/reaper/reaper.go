@@ -0,0 +1,74 @@
+package reaper
+
+import (
+	"log"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+func Start(
+	store *database.Store, storage fileupload.Storage,
+	interval time.Duration, batchSize int,
+) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			Reap(store, batchSize)
+			DeleteDueBlobs(store.Pastes, storage, batchSize)
+			purgeRevokedTokens(store)
+		}
+	}()
+}
+
+// Reap deletes expired pastes in batches until none are left or a batch
+// fails, leaving failed pastes for the next run.
+func Reap(store *database.Store, batchSize int) {
+	for {
+		pastes, err := store.Pastes.GetExpiredPastes(time.Now(), batchSize)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+
+		failed := false
+
+		for _, paste := range pastes {
+			err = reapPaste(store, &paste)
+			if err != nil {
+				log.Println(err)
+
+				failed = true
+			}
+		}
+
+		if len(pastes) > 0 {
+			log.Printf("Reaped %d expired pastes", len(pastes))
+		}
+
+		if failed || len(pastes) < batchSize {
+			return
+		}
+	}
+}
+
+// reapPaste deletes an expired paste's records. Its blobs are left
+// scheduled for DeleteDueBlobs, which runs after each reap.
+func reapPaste(store *database.Store, paste *models.Paste) error {
+	_, err := store.Pastes.DeletePaste(paste)
+
+	return err
+}
+
+// Expired tokens are rejected anyway, so they can leave the denylist.
+func purgeRevokedTokens(store *database.Store) {
+	err := store.Users.DeleteExpiredRevokedTokens(time.Now())
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -3,9 +3,15 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/XanderWatson/tasty-pastey/auth"
 	"github.com/XanderWatson/tasty-pastey/controllers"
-	"github.com/XanderWatson/tasty-pastey/middlewares"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/internal/reaper"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -16,6 +22,30 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rekey":
+			err = rekeyStorage()
+		case "migrate":
+			err = migrateSchema(os.Args[2:])
+		case "fsck":
+			err = checkConsistency(os.Args[2:])
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	store, err := database.Open(os.Getenv("DATABASE_BACKEND"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mode := os.Getenv("MODE")
 	if mode == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -23,25 +53,85 @@ func main() {
 		gin.SetMode(gin.DebugMode)
 	}
 
-	r := gin.Default()
+	reaperInterval, err := time.ParseDuration(getEnv("REAPER_INTERVAL", "1m"))
+	if err != nil {
+		log.Fatal("Invalid REAPER_INTERVAL", err)
+	}
+
+	reaperBatchSize, err := strconv.Atoi(getEnv("REAPER_BATCH_SIZE", "100"))
+	if err != nil || reaperBatchSize <= 0 {
+		log.Fatal("Invalid REAPER_BATCH_SIZE", err)
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reaper.Start(store, storage, reaperInterval, reaperBatchSize)
 
-	auth := r.Group("/auth/v1")
-	{
-		auth.POST("/signup", controllers.SignupController)
-		auth.POST("/login", controllers.LoginController)
+	keys, err := auth.LoadKeySet()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys: ", err)
 	}
 
-	v1 := r.Group("/api/v1").Use(middlewares.Authz())
-	{
-		v1.POST("/paste", controllers.CreatePasteController)
-		v1.GET("/paste", controllers.GetPastesController)
-		v1.GET("/paste/:id", controllers.GetPasteController)
-		v1.GET("/paste/:id/file", controllers.GetPasteFileController)
-		v1.PUT("/paste/:id", controllers.UpdatePasteController)
-		v1.DELETE("/paste/:id", controllers.DeletePasteController)
-		v1.POST("/share", controllers.CreatePasteAccessController)
-		v1.DELETE("/share", controllers.DeletePasteAccessController)
+	shareLinks, err := auth.LoadShareLinkSigner()
+	if err != nil {
+		log.Fatal("Failed to load share link secret: ", err)
+	}
+
+	h := controllers.NewHandler(store, storage, keys, shareLinks)
+
+	allowAnonymous, err := strconv.ParseBool(
+		getEnv("ALLOW_ANONYMOUS_PASTES", "false"),
+	)
+	if err != nil {
+		log.Fatal("Invalid ALLOW_ANONYMOUS_PASTES", err)
+	}
+
+	r := newRouter(store, h, allowAnonymous)
+
+	// Client addresses, which password attempts are counted by, are only
+	// read from the forwarding headers of these proxies.
+	err = r.SetTrustedProxies(trustedProxies())
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES", err)
 	}
 
 	r.Run("0.0.0.0:8000")
 }
+
+// openStorage builds the blob storage configured by STORAGE_BACKEND.
+func openStorage() (fileupload.Storage, error) {
+	return fileupload.Open(fileupload.Config{
+		Backend:           os.Getenv("STORAGE_BACKEND"),
+		BucketName:        os.Getenv("BUCKET_NAME"),
+		Path:              os.Getenv("STORAGE_PATH"),
+		EncryptionKeyFile: os.Getenv("STORAGE_ENCRYPTION_KEY_FILE"),
+	})
+}
+
+// trustedProxies reads TRUSTED_PROXIES, a comma-separated list of proxy
+// addresses or CIDR ranges. None are trusted by default.
+func trustedProxies() []string {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return nil
+	}
+
+	proxies := strings.Split(value, ",")
+	for i, proxy := range proxies {
+		proxies[i] = strings.TrimSpace(proxy)
+	}
+
+	return proxies
+}
+
+func getEnv(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
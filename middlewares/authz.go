@@ -4,22 +4,47 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/XanderWatson/tasty-pastey/auth"
 	"github.com/XanderWatson/tasty-pastey/database"
-	"github.com/XanderWatson/tasty-pastey/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-func Authz() gin.HandlerFunc {
+func Authz(
+	store *database.Store, keys *auth.KeySet, shareLinks *auth.ShareLinkSigner,
+) gin.HandlerFunc {
+	return authz(store, keys, shareLinks, false)
+}
+
+// OptionalAuthz is Authz for the routes that anonymous pastes are created,
+// updated and deleted through. When allowAnonymous is set, requests without
+// an Authorization header are let through with no "email" in the context.
+func OptionalAuthz(
+	store *database.Store, keys *auth.KeySet, shareLinks *auth.ShareLinkSigner,
+	allowAnonymous bool,
+) gin.HandlerFunc {
+	return authz(store, keys, shareLinks, allowAnonymous)
+}
+
+func authz(
+	store *database.Store, keys *auth.KeySet, shareLinks *auth.ShareLinkSigner,
+	allowAnonymous bool,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		pasteId, found := c.Params.Get("id")
 		if found {
-			var paste models.Paste
+			paste, err := store.Pastes.GetPasteByID(pasteId)
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Paste not found",
+				})
+				c.Abort()
 
-			result := database.DB.Where("id = ?", pasteId).First(&paste)
-			if result.Error != nil {
-				log.Println(result.Error)
+				return
+			} else if err != nil {
+				log.Println(err)
 
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"message": "Error fetching paste",
@@ -29,17 +54,34 @@ func Authz() gin.HandlerFunc {
 				return
 			}
 
-			if paste.Visibility == 0 {
-				if c.Request.Method == "GET" {
-					c.Next()
+			// Open pastes can be read without logging in, but a token that is
+			// sent is still checked so that its user's role on the paste
+			// counts.
+			if paste.Visibility.Open() && c.Request.Method == "GET" &&
+				c.Request.Header.Get("Authorization") == "" {
+				c.Next()
 
-					return
-				}
+				return
 			}
 		}
 
 		clientToken := c.Request.Header.Get("Authorization")
 
+		shareToken := shareLinkToken(c)
+		if clientToken == "" && shareToken != "" && found {
+			if authenticateShareLink(c, store, shareLinks, shareToken, pasteId) {
+				c.Next()
+			}
+
+			return
+		}
+
+		if clientToken == "" && allowAnonymous {
+			c.Next()
+
+			return
+		}
+
 		if clientToken == "" {
 			c.JSON(http.StatusForbidden, gin.H{
 				"message": "No Authorization header provided",
@@ -61,12 +103,20 @@ func Authz() gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(clientToken, auth.APITokenPrefix) {
+			if authenticateAPIToken(c, store, clientToken) {
+				c.Next()
+			}
+
+			return
+		}
+
 		Jwt := auth.Jwt{
-			SecretKey: "verysecretkey",
-			Issuer:    "AuthService",
+			Keys:   keys,
+			Issuer: "AuthService",
 		}
 
-		claims, err := Jwt.ValidateToken(clientToken)
+		claims, err := Jwt.ValidateToken(clientToken, auth.AccessToken)
 		if err != nil {
 			log.Println(err)
 
@@ -76,7 +126,30 @@ func Authz() gin.HandlerFunc {
 			return
 		}
 
+		revoked, err := store.Users.IsTokenRevoked(claims.Id)
+		if err != nil {
+			log.Println(err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error checking token",
+			})
+			c.Abort()
+
+			return
+		}
+
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Token has been revoked",
+			})
+			c.Abort()
+
+			return
+		}
+
 		c.Set("email", claims.Email)
+		c.Set("jti", claims.Id)
+		c.Set("tokenExpiresAt", time.Unix(claims.ExpiresAt, 0))
 		c.Next()
 	}
 }
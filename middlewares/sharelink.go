@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const shareLinkHeader = "Pastey-Share-Token"
+
+// ShareLinkQuery moves a share link token sent as the share query parameter,
+// so that links can be opened in a browser, into the Pastey-Share-Token
+// header. It runs before the logger, which would otherwise write the token
+// to the access log with the rest of the URL.
+func ShareLinkQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+
+		token := query.Get("share")
+		if token == "" {
+			c.Next()
+
+			return
+		}
+
+		if c.Request.Header.Get(shareLinkHeader) == "" {
+			c.Request.Header.Set(shareLinkHeader, token)
+		}
+
+		query.Del("share")
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+// shareLinkToken returns the share link token of a request, which is only
+// read from the Pastey-Share-Token header.
+func shareLinkToken(c *gin.Context) string {
+	return c.Request.Header.Get(shareLinkHeader)
+}
+
+// authenticateShareLink checks a share link token for the paste being
+// requested and counts the request as one of its views. The link is set as
+// "shareLink" in the context. If the link is not accepted, it writes the
+// error response and returns false.
+func authenticateShareLink(
+	c *gin.Context, store *database.Store, shareLinks *auth.ShareLinkSigner,
+	token string, pasteId string,
+) bool {
+	linkId, err := shareLinks.Verify(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid share link",
+		})
+		c.Abort()
+
+		return false
+	}
+
+	link, err := store.Access.GetShareLinkByID(linkId)
+	if err == gorm.ErrRecordNotFound || (err == nil && link.PasteID != pasteId) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid share link",
+		})
+		c.Abort()
+
+		return false
+	} else if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error checking share link",
+		})
+		c.Abort()
+
+		return false
+	}
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead &&
+		link.Scope != models.ShareLinkWrite {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "This share link is read-only",
+		})
+		c.Abort()
+
+		return false
+	}
+
+	used, err := store.Access.UseShareLink(link.ID, time.Now())
+	if err != nil {
+		log.Println(err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error checking share link",
+		})
+		c.Abort()
+
+		return false
+	}
+
+	if !used {
+		message := "This share link has no views left"
+		if link.RevokedAt != nil {
+			message = "This share link has been revoked"
+		} else if link.Expired() {
+			message = "This share link has expired"
+		}
+
+		c.JSON(http.StatusGone, gin.H{
+			"message": message,
+		})
+		c.Abort()
+
+		return false
+	}
+
+	c.Set("shareLink", link)
+
+	return true
+}
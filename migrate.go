@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/XanderWatson/tasty-pastey/database"
+)
+
+// migrateSchema runs migrate up, down [steps] or status against the SQL
+// database at DATABASE_URL.
+func migrateSchema(args []string) error {
+	backend := os.Getenv("DATABASE_BACKEND")
+	if backend != "" && backend != "sql" {
+		return fmt.Errorf("the %q database backend has no schema to migrate", backend)
+	}
+
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [steps] | status")
+	}
+
+	store, err := database.ConnectSQL(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp()
+
+		for _, migration := range applied {
+			log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+		}
+
+		if err == nil && len(applied) == 0 {
+			log.Println("No migrations to apply")
+		}
+
+		return err
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := store.MigrateDown(steps)
+
+		for _, migration := range reverted {
+			log.Printf("Reverted migration %04d_%s", migration.Version, migration.Name)
+		}
+
+		return err
+	case "status":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,18 +16,216 @@ type User struct {
 }
 
 type Paste struct {
-	ID         string    `json:"id" gorm:"primaryKey"`
-	Title      string    `json:"title"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
-	Visibility int       `json:"visibility"`
-	UserID     uuid.UUID `json:"user_id"`
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Title      string     `json:"title"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Visibility Visibility `json:"visibility"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"index"`
+	ExpiresAt  *time.Time `json:"expires_at" form:"expires_at" gorm:"index"`
+
+	BurnAfterReading bool `json:"burn_after_reading" form:"burn_after_reading"`
+
+	Size        int64  `json:"size"`
+	ContentHash string `json:"content_hash"`
+	Language    string `json:"language" form:"language"`
+	Revision    int    `json:"revision"`
+	BlobKey     string `json:"-"`
+
+	// Set only for anonymous pastes, which have no owner to check against.
+	EditTokenHash string `json:"-" form:"-"`
+	PasswordHash  string `json:"-" form:"-"`
+
+	// Pastes owned by an organization are managed by its admins.
+	OrganizationID *uuid.UUID `json:"organization_id" form:"-" gorm:"index"`
+
+	// Encrypted pastes are encrypted by the client, which keeps the key.
+	Encrypted           bool   `json:"encrypted" form:"-"`
+	EncryptionAlgorithm string `json:"encryption_algorithm" form:"-"`
+}
+
+// Anonymous pastes have no owner and are edited with their edit token.
+func (p *Paste) Anonymous() bool {
+	return p.UserID == uuid.Nil
+}
+
+func (p *Paste) Expired() bool {
+	return p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now())
+}
+
+// StorageKey is the key of the paste's current content. Pastes created
+// before revisions were introduced are stored under their ID.
+func (p *Paste) StorageKey() string {
+	if p.BlobKey == "" {
+		return p.ID
+	}
+
+	return p.BlobKey
+}
+
+type PasteRevision struct {
+	ID          uuid.UUID `json:"id" gorm:"primaryKey"`
+	PasteID     string    `json:"paste_id" gorm:"uniqueIndex:idx_paste_revision_number"`
+	Number      int       `json:"number" gorm:"uniqueIndex:idx_paste_revision_number"`
+	UserID      uuid.UUID `json:"user_id"`
+	Size        int64     `json:"size"`
+	ContentHash string    `json:"content_hash"`
+	Language    string    `json:"language"`
+	BlobKey     string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func RevisionKey(pasteId string, revisionId uuid.UUID) string {
+	return "pastes/" + pasteId + "/" + revisionId.String()
+}
+
+// KeyPasteID returns the ID of the paste a storage key belongs to. Keys
+// from before revisions existed are the paste's ID.
+func KeyPasteID(key string) string {
+	rest, found := strings.CutPrefix(key, "pastes/")
+	if !found {
+		return key
+	}
+
+	pasteId, _, _ := strings.Cut(rest, "/")
+
+	return pasteId
+}
+
+// BlobDeletion is a stored blob waiting to be deleted. Deletions are
+// recorded in the same transaction that stops the blob being used, and are
+// retried until storage confirms them, so records and storage can't drift
+// apart.
+type BlobDeletion struct {
+	Key       string    `gorm:"primaryKey"`
+	NotBefore time.Time `gorm:"index"`
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
+
+// PasteSearchDocument holds the text of a paste's current revision for
+// full-text search. Pastes that aren't text, or are encrypted, only have
+// their title indexed.
+type PasteSearchDocument struct {
+	PasteID   string `gorm:"primaryKey"`
+	Content   string `gorm:"type:text"`
+	Vector    string `gorm:"type:tsvector;index:idx_paste_search_vector,type:gin"`
+	UpdatedAt time.Time
 }
 
 type PasteAccess struct {
 	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
-	PasteID   string    `json:"paste_id"`
-	UserID    uuid.UUID `json:"user_id"`
+	PasteID   string    `json:"paste_id" gorm:"uniqueIndex:idx_paste_access_user;index"`
+	UserID    uuid.UUID `json:"user_id" gorm:"uniqueIndex:idx_paste_access_user;index"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// AccessRole is the role of the grant. Grants from before roles existed are
+// viewers.
+func (a *PasteAccess) AccessRole() string {
+	if a.Role == "" {
+		return RoleViewer
+	}
+
+	return a.Role
+}
+
+type TeamPasteAccess struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
+	TeamID    uuid.UUID `json:"team_id" gorm:"uniqueIndex:idx_team_paste_access"`
+	PasteID   string    `json:"paste_id" gorm:"uniqueIndex:idx_team_paste_access;index"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	ShareLinkRead  = "read"
+	ShareLinkWrite = "write"
+)
+
+// ShareLink lets anyone holding its token use a paste without an account.
+// MaxViews of 0 means unlimited.
+type ShareLink struct {
+	ID         uuid.UUID  `json:"id" gorm:"primaryKey"`
+	PasteID    string     `json:"paste_id" gorm:"index"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	MaxViews   int        `json:"max_views"`
+	Views      int        `json:"views"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (l *ShareLink) Expired() bool {
+	return l.ExpiresAt != nil && !l.ExpiresAt.After(time.Now())
+}
+
+// Role is the role the link grants on its paste.
+func (l *ShareLink) Role() string {
+	if l.Scope == ShareLinkWrite {
+		return RoleEditor
+	}
+
+	return RoleViewer
+}
+
+const (
+	OrganizationAdmin  = "admin"
+	OrganizationMember = "member"
+)
+
+type Organization struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"unique"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrganizationMembership struct {
+	ID             uuid.UUID `json:"id" gorm:"primaryKey"`
+	OrganizationID uuid.UUID `json:"organization_id" gorm:"uniqueIndex:idx_organization_membership"`
+	UserID         uuid.UUID `json:"user_id" gorm:"uniqueIndex:idx_organization_membership;index"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type Team struct {
+	ID             uuid.UUID `json:"id" gorm:"primaryKey"`
+	OrganizationID uuid.UUID `json:"organization_id" gorm:"uniqueIndex:idx_organization_team_name"`
+	Name           string    `json:"name" gorm:"uniqueIndex:idx_organization_team_name"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type TeamMembership struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
+	TeamID    uuid.UUID `json:"team_id" gorm:"uniqueIndex:idx_team_membership"`
+	UserID    uuid.UUID `json:"user_id" gorm:"uniqueIndex:idx_team_membership;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIToken struct {
+	ID         uuid.UUID  `json:"id" gorm:"primaryKey"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     string     `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
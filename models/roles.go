@@ -0,0 +1,39 @@
+package models
+
+// Roles of access grants on a paste, from least to most privileged.
+// Viewers can read, editors can also update, and co-owners can also delete
+// the paste and manage its grants. The owner role only marks the creator's
+// own grant; who owns a paste is decided by the paste itself.
+const (
+	RoleViewer  = "viewer"
+	RoleEditor  = "editor"
+	RoleCoOwner = "co-owner"
+	RoleOwner   = "owner"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:  1,
+	RoleEditor:  2,
+	RoleCoOwner: 3,
+	RoleOwner:   4,
+}
+
+// RoleAtLeast reports whether role grants everything minimum does. No role
+// grants nothing.
+func RoleAtLeast(role string, minimum string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[minimum]
+}
+
+// HigherRole returns the more privileged of two roles.
+func HigherRole(a string, b string) string {
+	if roleRanks[b] > roleRanks[a] {
+		return b
+	}
+
+	return a
+}
+
+// ValidGrantRole reports whether a role can be granted to someone.
+func ValidGrantRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleCoOwner
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Visibility controls who can read a paste. It is stored and serialized as
+// an integer; the values of public and private predate the type.
+type Visibility int
+
+const (
+	Public Visibility = iota
+	Private
+	// Unlisted pastes can be read by anyone with the ID, but never show up
+	// in listings or search.
+	Unlisted
+	SharedWithLink
+	OrgOnly
+)
+
+var visibilityNames = map[Visibility]string{
+	Public:         "public",
+	Private:        "private",
+	Unlisted:       "unlisted",
+	SharedWithLink: "shared-with-link",
+	OrgOnly:        "org-only",
+}
+
+func (v Visibility) String() string {
+	name, ok := visibilityNames[v]
+	if !ok {
+		return strconv.Itoa(int(v))
+	}
+
+	return name
+}
+
+func (v Visibility) Valid() bool {
+	_, ok := visibilityNames[v]
+
+	return ok
+}
+
+// Open reports whether the paste can be read without logging in.
+func (v Visibility) Open() bool {
+	return v == Public || v == Unlisted
+}
+
+// Listed reports whether the paste may appear in listings and search results
+// of users other than its owner.
+func (v Visibility) Listed() bool {
+	return v != Unlisted && v != SharedWithLink
+}
+
+// ParseVisibility accepts either the name or the number of a visibility.
+func ParseVisibility(value string) (Visibility, error) {
+	for v, name := range visibilityNames {
+		if value == name {
+			return v, nil
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || !Visibility(number).Valid() {
+		return 0, fmt.Errorf("invalid visibility %q", value)
+	}
+
+	return Visibility(number), nil
+}
+
+func (v *Visibility) UnmarshalJSON(data []byte) error {
+	var value interface{}
+
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case string:
+		*v, err = ParseVisibility(value)
+
+		return err
+	case float64:
+		if value != float64(int(value)) || !Visibility(value).Valid() {
+			return fmt.Errorf("invalid visibility %v", value)
+		}
+
+		*v = Visibility(value)
+
+		return nil
+	}
+
+	return fmt.Errorf("invalid visibility %s", data)
+}
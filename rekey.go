@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+)
+
+// rekeyStorage rewraps every stored blob with the current master key, so
+// older keys can be removed from the key file afterwards.
+func rekeyStorage() error {
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	store, ok := storage.(*fileupload.EncryptedStorage)
+	if !ok {
+		return errors.New("STORAGE_ENCRYPTION_KEY_FILE is not set")
+	}
+
+	var seen, rekeyed int
+
+	err = store.Walk(func(key string) error {
+		changed, err := store.Rekey(key)
+		if errors.Is(err, fileupload.ErrNotFound) {
+			// Deleted since it was listed.
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		seen++
+
+		if changed {
+			rekeyed++
+		}
+
+		if seen%100 == 0 {
+			log.Printf("Checked %d blobs, rekeyed %d", seen, rekeyed)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Checked %d blobs, rekeyed %d with key %q",
+		seen, rekeyed, store.Keys.KeyID(),
+	)
+
+	return nil
+}
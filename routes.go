@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/controllers"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+// newRouter registers the API's routes. When allowAnonymous is set, pastes
+// can be created without signing in.
+func newRouter(
+	store *database.Store, h *controllers.Handler, allowAnonymous bool,
+) *gin.Engine {
+	r := gin.New()
+	r.Use(middlewares.ShareLinkQuery(), gin.Logger(), gin.Recovery())
+
+	authz := middlewares.Authz(store, h.Keys, h.ShareLinks)
+
+	r.GET("/.well-known/jwks.json", h.JWKSController)
+
+	authRoutes := r.Group("/auth/v1")
+	{
+		authRoutes.POST("/signup", h.SignupController)
+		authRoutes.POST("/login", h.LoginController)
+		authRoutes.POST("/refresh", h.RefreshController)
+		authRoutes.POST(
+			"/logout",
+			authz, middlewares.RequireSession(),
+			h.LogoutController,
+		)
+	}
+
+	read := middlewares.RequireScope(auth.ScopePasteRead)
+	write := middlewares.RequireScope(auth.ScopePasteWrite)
+	share := middlewares.RequireScope(auth.ScopeShareManage)
+	session := middlewares.RequireSession()
+
+	anonymous := r.Group("/api/v1").Use(
+		middlewares.OptionalAuthz(store, h.Keys, h.ShareLinks, allowAnonymous),
+	)
+	{
+		anonymous.POST("/paste", write, h.CreatePasteController)
+		anonymous.PUT("/paste/:id", write, h.UpdatePasteController)
+		anonymous.DELETE("/paste/:id", write, h.DeletePasteController)
+		anonymous.POST(
+			"/paste/:id/revisions/:revision/restore",
+			write, h.RestorePasteRevisionController,
+		)
+	}
+
+	v1 := r.Group("/api/v1").Use(authz)
+	{
+		v1.GET("/paste", read, h.GetPastesController)
+		v1.GET("/paste/:id", read, h.GetPasteController)
+		v1.GET("/paste/:id/file", read, h.GetPasteFileController)
+		v1.GET("/paste/:id/raw", read, h.GetPasteRawController)
+		v1.GET("/paste/:id/view", read, h.GetPasteViewController)
+		v1.GET(
+			"/paste/:id/revisions", read, h.GetPasteRevisionsController,
+		)
+		v1.GET(
+			"/paste/:id/revisions/:revision",
+			read, h.GetPasteRevisionController,
+		)
+		v1.GET("/paste/:id/diff", read, h.GetPasteDiffController)
+		v1.GET("/search", read, h.SearchPastesController)
+		v1.GET("/paste/:id/shares", share, h.GetPasteAccessesController)
+		v1.POST("/paste/:id/links", share, h.CreateShareLinkController)
+		v1.GET("/paste/:id/links", share, h.GetShareLinksController)
+		v1.DELETE(
+			"/paste/:id/links/:linkId",
+			share, h.RevokeShareLinkController,
+		)
+		v1.POST("/share", share, h.CreatePasteAccessController)
+		v1.PUT("/share", share, h.UpdatePasteAccessController)
+		v1.DELETE("/share", share, h.DeletePasteAccessController)
+		v1.POST("/orgs", session, h.CreateOrganizationController)
+		v1.GET("/orgs", session, h.GetOrganizationsController)
+		v1.GET(
+			"/orgs/:orgId/members",
+			session, h.GetOrganizationMembersController,
+		)
+		v1.POST(
+			"/orgs/:orgId/members",
+			session, h.AddOrganizationMemberController,
+		)
+		v1.DELETE(
+			"/orgs/:orgId/members/:userId",
+			session, h.RemoveOrganizationMemberController,
+		)
+		v1.GET("/orgs/:orgId/teams", session, h.GetTeamsController)
+		v1.POST("/orgs/:orgId/teams", session, h.CreateTeamController)
+		v1.DELETE(
+			"/orgs/:orgId/teams/:teamId",
+			session, h.DeleteTeamController,
+		)
+		v1.GET(
+			"/orgs/:orgId/teams/:teamId/members",
+			session, h.GetTeamMembersController,
+		)
+		v1.POST(
+			"/orgs/:orgId/teams/:teamId/members",
+			session, h.AddTeamMemberController,
+		)
+		v1.DELETE(
+			"/orgs/:orgId/teams/:teamId/members/:userId",
+			session, h.RemoveTeamMemberController,
+		)
+		v1.POST("/tokens", session, h.CreateAPITokenController)
+		v1.GET("/tokens", session, h.GetAPITokensController)
+		v1.DELETE(
+			"/tokens/:tokenId", session, h.DeleteAPITokenController,
+		)
+	}
+
+	return r
+}
@@ -0,0 +1,1225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/XanderWatson/tasty-pastey/auth"
+	"github.com/XanderWatson/tasty-pastey/controllers"
+	"github.com/XanderWatson/tasty-pastey/database"
+	"github.com/XanderWatson/tasty-pastey/internal/fileupload"
+	"github.com/XanderWatson/tasty-pastey/internal/reaper"
+	"github.com/XanderWatson/tasty-pastey/models"
+)
+
+// testServer runs the API on the in-memory database and storage.
+type testServer struct {
+	t       *testing.T
+	router  *gin.Engine
+	handler *controllers.Handler
+	store   *database.Store
+	storage *fileupload.MemoryStorage
+}
+
+type testResponse struct {
+	*httptest.ResponseRecorder
+	t *testing.T
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	memory := database.NewMemory()
+
+	return newTestServerWithStore(
+		t, &database.Store{Users: memory, Pastes: memory, Access: memory},
+	)
+}
+
+// newTestServerWithStore runs the API on store, such as the in-memory one
+// with some of its methods wrapped.
+func newTestServerWithStore(t *testing.T, store *database.Store) *testServer {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("JWT_ALGORITHM", "HS256")
+	t.Setenv("JWT_KEY_ID", "test")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_SECRET_FILE", "")
+	t.Setenv("JWT_VERIFICATION_KEYS_DIR", "")
+
+	keys, err := auth.LoadKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := fileupload.NewMemoryStorage()
+
+	h := controllers.NewHandler(
+		store, storage, keys, auth.NewShareLinkSigner([]byte("test-secret")),
+	)
+
+	return &testServer{
+		t:       t,
+		router:  newRouter(store, h, true),
+		handler: h,
+		store:   store,
+		storage: storage,
+	}
+}
+
+// do sends a request, with a bearer token unless token is empty. Other
+// headers are given as name and value pairs.
+func (s *testServer) do(
+	method string, path string, token string, body io.Reader, headers ...string,
+) *testResponse {
+	s.t.Helper()
+
+	req := httptest.NewRequest(method, path, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	return &testResponse{ResponseRecorder: rec, t: s.t}
+}
+
+func (s *testServer) doJSON(
+	method string, path string, token string, payload interface{},
+) *testResponse {
+	s.t.Helper()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return s.do(
+		method, path, token, bytes.NewReader(body),
+		"Content-Type", "application/json",
+	)
+}
+
+// doFile sends content as the file part of a multipart request.
+func (s *testServer) doFile(
+	method string, path string, token string, content string, headers ...string,
+) *testResponse {
+	s.t.Helper()
+
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("file", "paste.txt")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	part.Write([]byte(content))
+	writer.Close()
+
+	headers = append(headers, "Content-Type", writer.FormDataContentType())
+
+	return s.do(method, path, token, &body, headers...)
+}
+
+func (r *testResponse) expect(status int) *testResponse {
+	r.t.Helper()
+
+	if r.Code != status {
+		r.t.Fatalf("got status %d, want %d: %s", r.Code, status, r.Body.String())
+	}
+
+	return r
+}
+
+func (r *testResponse) decode(v interface{}) {
+	r.t.Helper()
+
+	err := json.Unmarshal(r.Body.Bytes(), v)
+	if err != nil {
+		r.t.Fatalf("decoding %s: %v", r.Body.String(), err)
+	}
+}
+
+// signup adds a user and logs them in, returning their tokens. Users are
+// stored directly with a cheap hash, as signing up hashes at full cost.
+func (s *testServer) signup(email string) controllers.LoginResponse {
+	s.t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	err = s.store.Users.CreateUserRecord(&models.User{
+		ID: uuid.New(), Email: email, Password: string(hash),
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	credentials := gin.H{"email": email, "password": "correct horse"}
+
+	var tokens controllers.LoginResponse
+
+	s.doJSON("POST", "/auth/v1/login", "", credentials).
+		expect(http.StatusOK).decode(&tokens)
+
+	return tokens
+}
+
+func (s *testServer) createPaste(
+	token string, content string, headers ...string,
+) models.Paste {
+	s.t.Helper()
+
+	var created struct {
+		Data models.Paste `json:"data"`
+	}
+
+	headers = append([]string{"Pastey-Title", "test"}, headers...)
+
+	s.doFile("POST", "/api/v1/paste", token, content, headers...).
+		expect(http.StatusCreated).decode(&created)
+
+	return created.Data
+}
+
+func (s *testServer) share(token string, pasteId string, email string, role string) *testResponse {
+	s.t.Helper()
+
+	query := url.Values{
+		"paste_id":   {pasteId},
+		"user_email": {email},
+		"role":       {role},
+	}
+
+	return s.do("POST", "/api/v1/share?"+query.Encode(), token, nil)
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	s := newTestServer(t)
+	credentials := gin.H{"email": "alice@example.com", "password": "correct horse"}
+
+	s.doJSON("POST", "/auth/v1/signup", "", credentials).expect(http.StatusOK)
+
+	var tokens controllers.LoginResponse
+
+	s.doJSON("POST", "/auth/v1/login", "", credentials).
+		expect(http.StatusOK).decode(&tokens)
+
+	s.doJSON("POST", "/auth/v1/login", "", gin.H{
+		"email": "alice@example.com", "password": "wrong",
+	}).expect(http.StatusUnauthorized)
+
+	s.doJSON("POST", "/auth/v1/login", "", gin.H{
+		"email": "nobody@example.com", "password": "correct horse",
+	}).expect(http.StatusUnauthorized)
+
+	s.do("GET", "/api/v1/paste", "", nil).expect(http.StatusForbidden)
+	s.do("GET", "/api/v1/paste", "not-a-token", nil).expect(http.StatusUnauthorized)
+	s.do("GET", "/api/v1/paste", tokens.Token, nil).expect(http.StatusOK)
+
+	// Refresh tokens can't stand in for access tokens.
+	s.do("GET", "/api/v1/paste", tokens.RefreshToken, nil).
+		expect(http.StatusUnauthorized)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	s := newTestServer(t)
+	tokens := s.signup("alice@example.com")
+
+	var refreshed controllers.LoginResponse
+
+	s.doJSON("POST", "/auth/v1/refresh", "", gin.H{
+		"refreshtoken": tokens.RefreshToken,
+	}).expect(http.StatusOK).decode(&refreshed)
+
+	s.do("GET", "/api/v1/paste", refreshed.Token, nil).expect(http.StatusOK)
+
+	s.doJSON("POST", "/auth/v1/logout", refreshed.Token, gin.H{
+		"refreshtoken": refreshed.RefreshToken,
+	}).expect(http.StatusOK)
+
+	s.do("GET", "/api/v1/paste", refreshed.Token, nil).
+		expect(http.StatusUnauthorized)
+
+	s.doJSON("POST", "/auth/v1/refresh", "", gin.H{
+		"refreshtoken": refreshed.RefreshToken,
+	}).expect(http.StatusUnauthorized)
+
+	// Other sessions are left alone.
+	s.do("GET", "/api/v1/paste", tokens.Token, nil).expect(http.StatusOK)
+}
+
+func TestPrivatePasteNeedsAccess(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+	bob := s.signup("bob@example.com")
+
+	paste := s.createPaste(alice.Token, "secret", "Pastey-Visibility", "private")
+
+	s.do("GET", "/api/v1/paste/"+paste.ID, "", nil).expect(http.StatusForbidden)
+	s.do("GET", "/api/v1/paste/"+paste.ID, bob.Token, nil).
+		expect(http.StatusUnauthorized)
+
+	body := s.do("GET", "/api/v1/paste/"+paste.ID+"/file", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if body != "secret" {
+		t.Errorf("file = %q, want %q", body, "secret")
+	}
+}
+
+func TestPasteRevisions(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "one\n", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	s.doFile("PUT", path, alice.Token, "two\n").expect(http.StatusOK)
+
+	var revisions struct {
+		Data []models.PasteRevision `json:"data"`
+	}
+
+	s.do("GET", path+"/revisions", alice.Token, nil).
+		expect(http.StatusOK).decode(&revisions)
+
+	if len(revisions.Data) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions.Data))
+	}
+
+	first := s.do("GET", path+"/revisions/1", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if first != "one\n" {
+		t.Errorf("revision 1 = %q, want %q", first, "one\n")
+	}
+
+	diff := s.do("GET", path+"/diff", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if !strings.Contains(diff, "-one") || !strings.Contains(diff, "+two") {
+		t.Errorf("diff doesn't show the change:\n%s", diff)
+	}
+
+	var restored struct {
+		Data models.PasteRevision `json:"data"`
+	}
+
+	s.do("POST", path+"/revisions/1/restore", alice.Token, nil).
+		expect(http.StatusCreated).decode(&restored)
+
+	if restored.Data.Number != 3 {
+		t.Errorf("restored as revision %d, want 3", restored.Data.Number)
+	}
+
+	current := s.do("GET", path+"/file", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if current != "one\n" {
+		t.Errorf("content after restoring = %q, want %q", current, "one\n")
+	}
+
+	s.do("GET", path+"/revisions/9", alice.Token, nil).
+		expect(http.StatusNotFound)
+}
+
+// racingPastes commits another revision right after the first one the
+// handler commits, as a concurrent edit would.
+type racingPastes struct {
+	database.PasteStore
+	raced bool
+}
+
+func (r *racingPastes) CommitPasteRevision(revision *models.PasteRevision) error {
+	err := r.PasteStore.CommitPasteRevision(revision)
+	if err != nil || r.raced {
+		return err
+	}
+
+	r.raced = true
+
+	concurrent := &models.PasteRevision{ID: uuid.New(), PasteID: revision.PasteID}
+	concurrent.BlobKey = models.RevisionKey(revision.PasteID, concurrent.ID)
+
+	return r.PasteStore.CommitPasteRevision(concurrent)
+}
+
+func TestUpdateKeepsConcurrentRevisions(t *testing.T) {
+	memory := database.NewMemory()
+	s := newTestServerWithStore(t, &database.Store{
+		Users: memory, Pastes: &racingPastes{PasteStore: memory}, Access: memory,
+	})
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "one\n", "Pastey-Visibility", "private")
+
+	s.doFile(
+		"PUT", "/api/v1/paste/"+paste.ID, alice.Token, "two\n",
+		"Pastey-Title", "renamed",
+	).expect(http.StatusOK)
+
+	updated, err := memory.GetPasteByID(paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Revision != 3 {
+		t.Errorf("paste is at revision %d, want the concurrent revision 3", updated.Revision)
+	}
+
+	if updated.Title != "renamed" {
+		t.Errorf("title = %q, want renamed", updated.Title)
+	}
+}
+
+func TestSharing(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+	bob := s.signup("bob@example.com")
+
+	paste := s.createPaste(alice.Token, "notes", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	// Only co-owners can share.
+	s.share(bob.Token, paste.ID, "bob@example.com", models.RoleViewer).
+		expect(http.StatusUnauthorized)
+
+	s.share(alice.Token, paste.ID, "bob@example.com", models.RoleViewer).
+		expect(http.StatusCreated)
+	s.share(alice.Token, paste.ID, "bob@example.com", models.RoleViewer).
+		expect(http.StatusConflict)
+
+	s.do("GET", path, bob.Token, nil).expect(http.StatusOK)
+	s.doFile("PUT", path, bob.Token, "edited").expect(http.StatusUnauthorized)
+
+	query := url.Values{
+		"paste_id":   {paste.ID},
+		"user_email": {"bob@example.com"},
+		"role":       {models.RoleEditor},
+	}
+
+	s.do("PUT", "/api/v1/share?"+query.Encode(), alice.Token, nil).
+		expect(http.StatusOK)
+
+	s.doFile("PUT", path, bob.Token, "edited").expect(http.StatusOK)
+
+	// Editors can't delete or reshare.
+	s.do("DELETE", path, bob.Token, nil).expect(http.StatusUnauthorized)
+	s.share(bob.Token, paste.ID, "alice@example.com", models.RoleViewer).
+		expect(http.StatusUnauthorized)
+
+	query.Del("role")
+
+	s.do("DELETE", "/api/v1/share?"+query.Encode(), alice.Token, nil).
+		expect(http.StatusOK)
+
+	s.do("GET", path, bob.Token, nil).expect(http.StatusUnauthorized)
+}
+
+func TestOwnerManagesSharesOfOpenPastes(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+	bob := s.signup("bob@example.com")
+
+	for _, visibility := range []string{"public", "unlisted"} {
+		paste := s.createPaste(alice.Token, visibility, "Pastey-Visibility", visibility)
+		path := "/api/v1/paste/" + paste.ID + "/shares"
+
+		var shares struct {
+			Data struct {
+				Users []models.PasteAccess `json:"users"`
+			} `json:"data"`
+		}
+
+		s.do("GET", path, alice.Token, nil).expect(http.StatusOK).decode(&shares)
+
+		if len(shares.Data.Users) == 0 {
+			t.Errorf("%s paste: the owner's shares aren't listed", visibility)
+		}
+
+		// Anyone can read the paste, but not manage it.
+		s.do("GET", path, bob.Token, nil).expect(http.StatusUnauthorized)
+		s.do("GET", path, "", nil).expect(http.StatusUnauthorized)
+		s.do("GET", "/api/v1/paste/"+paste.ID+"/file", "", nil).expect(http.StatusOK)
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "linked", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	var link struct {
+		Token string `json:"token"`
+	}
+
+	s.doJSON("POST", path+"/links", alice.Token, gin.H{"max_views": 1}).
+		expect(http.StatusCreated).decode(&link)
+
+	body := s.do("GET", path+"/file?share="+url.QueryEscape(link.Token), "", nil).
+		expect(http.StatusOK).Body.String()
+	if body != "linked" {
+		t.Errorf("file = %q, want %q", body, "linked")
+	}
+
+	s.do("GET", path+"/file?share="+url.QueryEscape(link.Token), "", nil).
+		expect(http.StatusGone)
+
+	s.do("GET", path+"/file", "", nil, "Pastey-Share-Token", "psl_forged.token").
+		expect(http.StatusUnauthorized)
+
+	// Read-only links can't edit.
+	s.doJSON("POST", path+"/links", alice.Token, gin.H{}).
+		expect(http.StatusCreated).decode(&link)
+
+	s.doFile("PUT", path, "", "edited", "Pastey-Share-Token", link.Token).
+		expect(http.StatusForbidden)
+}
+
+func TestShareLinkTokensStayOutOfLogs(t *testing.T) {
+	var logs bytes.Buffer
+
+	// The logger writes to where DefaultWriter pointed when it was made.
+	writer := gin.DefaultWriter
+	gin.DefaultWriter = &logs
+	t.Cleanup(func() { gin.DefaultWriter = writer })
+
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "linked", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	var link struct {
+		Token string `json:"token"`
+	}
+
+	s.doJSON("POST", path+"/links", alice.Token, gin.H{}).
+		expect(http.StatusCreated).decode(&link)
+
+	s.do("GET", path+"/file?download=1&share="+url.QueryEscape(link.Token), "", nil).
+		expect(http.StatusOK)
+
+	if !strings.Contains(logs.String(), path+"/file?download=1") {
+		t.Errorf("request wasn't logged:\n%s", logs.String())
+	}
+
+	if strings.Contains(logs.String(), link.Token) {
+		t.Errorf("share link token was logged:\n%s", logs.String())
+	}
+}
+
+func TestOwnerManagesLinksOfOpenPastes(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	for _, visibility := range []string{"public", "unlisted"} {
+		paste := s.createPaste(alice.Token, visibility, "Pastey-Visibility", visibility)
+		path := "/api/v1/paste/" + paste.ID + "/links"
+
+		s.doJSON("POST", path, alice.Token, gin.H{}).expect(http.StatusCreated)
+
+		var links struct {
+			Data []models.ShareLink `json:"data"`
+		}
+
+		s.do("GET", path, alice.Token, nil).expect(http.StatusOK).decode(&links)
+
+		if len(links.Data) != 1 {
+			t.Errorf("%s paste: listed %d links, want 1", visibility, len(links.Data))
+		}
+
+		s.do("GET", path, "", nil).expect(http.StatusUnauthorized)
+	}
+}
+
+func TestExpiredPasteCantBeUpdated(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "old", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	expired := time.Now().Add(-time.Minute)
+
+	err := s.store.Pastes.UpdatePasteExpiry(paste.ID, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.doFile("PUT", path, alice.Token, "new").expect(http.StatusGone)
+	s.doFile("PUT", path, alice.Token, "new", "Pastey-Expires-In", "never").
+		expect(http.StatusGone)
+	s.doJSON("PUT", path+"?metadata=true", alice.Token, gin.H{
+		"title":      "revived",
+		"expires_at": time.Now().Add(time.Hour),
+	}).expect(http.StatusGone)
+
+	revisions, err := s.store.Pastes.GetPasteRevisionsByPasteId(paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revisions) != 1 {
+		t.Errorf("expired paste has %d revisions, want 1", len(revisions))
+	}
+}
+
+func TestAnonymousRestore(t *testing.T) {
+	s := newTestServer(t)
+
+	var created struct {
+		Data      models.Paste `json:"data"`
+		EditToken string       `json:"edit_token"`
+	}
+
+	s.doFile(
+		"POST", "/api/v1/paste", "", "one",
+		"Pastey-Title", "anonymous", "Pastey-Visibility", "public",
+	).
+		expect(http.StatusCreated).decode(&created)
+
+	path := "/api/v1/paste/" + created.Data.ID
+
+	s.doFile("PUT", path, "", "two", "Pastey-Edit-Token", created.EditToken).
+		expect(http.StatusOK)
+
+	s.do("POST", path+"/revisions/1/restore", "", nil).
+		expect(http.StatusUnauthorized)
+	s.do("POST", path+"/revisions/1/restore", "", nil, "Pastey-Edit-Token", "pet_wrong").
+		expect(http.StatusUnauthorized)
+	s.do("POST", path+"/revisions/1/restore", "", nil, "Pastey-Edit-Token", created.EditToken).
+		expect(http.StatusCreated)
+
+	body := s.do("GET", path+"/file", "", nil).expect(http.StatusOK).Body.String()
+	if body != "one" {
+		t.Errorf("content after restoring = %q, want %q", body, "one")
+	}
+}
+
+func TestMetadataUpdateCantChangeOwnership(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+	bob := s.signup("bob@example.com")
+
+	paste := s.createPaste(alice.Token, "mine", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	s.share(alice.Token, paste.ID, "bob@example.com", models.RoleEditor).
+		expect(http.StatusCreated)
+
+	bobUser, err := s.store.Users.GetUserByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.doJSON("PUT", path+"?metadata=true", bob.Token, gin.H{
+		"id":                 "hijacked",
+		"title":              "renamed",
+		"user_id":            bobUser.ID,
+		"organization_id":    uuid.New(),
+		"burn_after_reading": true,
+		"encrypted":          true,
+	}).expect(http.StatusOK)
+
+	stored, err := s.store.Pastes.GetPasteByID(paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Title != "renamed" {
+		t.Errorf("title = %q, want %q", stored.Title, "renamed")
+	}
+
+	if stored.UserID != paste.UserID || stored.OrganizationID != nil {
+		t.Errorf(
+			"owner changed to user %s and organization %v",
+			stored.UserID, stored.OrganizationID,
+		)
+	}
+
+	if stored.BurnAfterReading || stored.Encrypted {
+		t.Error("a metadata update changed how the content is served")
+	}
+
+	// Bob is still only an editor.
+	s.do("DELETE", path, bob.Token, nil).expect(http.StatusUnauthorized)
+	s.share(bob.Token, paste.ID, "bob@example.com", models.RoleCoOwner).
+		expect(http.StatusUnauthorized)
+}
+
+func TestMetadataUpdateCanMakePastePublic(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "soon public", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	s.do("GET", path+"/file", "", nil).expect(http.StatusForbidden)
+
+	s.doJSON("PUT", path+"?metadata=true", alice.Token, gin.H{
+		"visibility": "public",
+	}).expect(http.StatusOK)
+
+	s.do("GET", path+"/file", "", nil).expect(http.StatusOK)
+
+	// Leaving visibility out keeps it.
+	s.doJSON("PUT", path+"?metadata=true", alice.Token, gin.H{
+		"title": "renamed",
+	}).expect(http.StatusOK)
+
+	stored, err := s.store.Pastes.GetPasteByID(paste.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Visibility != models.Public || stored.Title != "renamed" {
+		t.Errorf("paste is %s and titled %q", stored.Visibility, stored.Title)
+	}
+
+	s.doJSON("PUT", path+"?metadata=true", alice.Token, gin.H{
+		"visibility": "nonsense",
+	}).expect(http.StatusBadRequest)
+}
+
+func TestFileUpdateCanMakePastePublic(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "private", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	s.doFile("PUT", path, alice.Token, "public", "Pastey-Visibility", "public").
+		expect(http.StatusOK)
+
+	body := s.do("GET", path+"/file", "", nil).expect(http.StatusOK).Body.String()
+	if body != "public" {
+		t.Errorf("content = %q, want the new revision", body)
+	}
+
+	// Leaving the header out keeps the visibility.
+	s.doFile("PUT", path, alice.Token, "still public").expect(http.StatusOK)
+	s.do("GET", path+"/file", "", nil).expect(http.StatusOK)
+}
+
+func TestBurnAfterReadingSurvivesFailedViews(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	binary := s.createPaste(
+		alice.Token, "\xff\xfe\x00\x01binary",
+		"Pastey-Visibility", "private", "Pastey-Burn-After-Reading", "true",
+	)
+	path := "/api/v1/paste/" + binary.ID
+
+	s.do("GET", path+"/view", alice.Token, nil).
+		expect(http.StatusUnsupportedMediaType)
+
+	body := s.do("GET", path+"/file", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if body != "\xff\xfe\x00\x01binary" {
+		t.Errorf("file = %q after a failed view", body)
+	}
+
+	s.do("GET", path+"/file", alice.Token, nil).expect(http.StatusNotFound)
+
+	text := s.createPaste(
+		alice.Token, "read me once",
+		"Pastey-Visibility", "private", "Pastey-Burn-After-Reading", "true",
+	)
+	path = "/api/v1/paste/" + text.ID
+
+	page := s.do("GET", path+"/view", alice.Token, nil).
+		expect(http.StatusOK).Body.String()
+	if !strings.Contains(page, "read me once") {
+		t.Errorf("view doesn't show the content:\n%s", page)
+	}
+
+	s.do("GET", path+"/raw", alice.Token, nil).expect(http.StatusNotFound)
+
+	s.storage.Walk(func(key string) error {
+		t.Errorf("burned blob %q is still stored", key)
+
+		return nil
+	})
+}
+
+func TestPasswordProtectedPaste(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "hidden", "Pastey-Visibility", "public")
+	path := "/api/v1/paste/" + paste.ID + "/file"
+
+	// Set with a cheap hash, as every check costs as much as hashing.
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.store.Pastes.UpdatePastePassword(paste.ID, string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.do("GET", path, "", nil).expect(http.StatusUnauthorized)
+	s.do("GET", path, "", nil, "Pastey-Password", "open sesame").
+		expect(http.StatusOK)
+
+	for i := 0; i < 5; i++ {
+		s.do("GET", path, "", nil, "Pastey-Password", "guess").
+			expect(http.StatusUnauthorized)
+	}
+
+	s.do("GET", path, "", nil, "Pastey-Password", "open sesame").
+		expect(http.StatusTooManyRequests)
+}
+
+func TestPasteFileRanges(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "0123456789", "Pastey-Visibility", "public")
+	path := "/api/v1/paste/" + paste.ID + "/file"
+
+	etag := s.do("GET", path, "", nil).expect(http.StatusOK).Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+		body    string
+	}{
+		{"range", []string{"Range", "bytes=2-4"}, http.StatusPartialContent, "234"},
+		{"open-ended range", []string{"Range", "bytes=7-"}, http.StatusPartialContent, "789"},
+		{"suffix range", []string{"Range", "bytes=-2"}, http.StatusPartialContent, "89"},
+		{
+			"unsatisfiable range",
+			[]string{"Range", "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "",
+		},
+		{"malformed range", []string{"Range", "bytes=x-1"}, http.StatusOK, "0123456789"},
+		{"multiple ranges", []string{"Range", "bytes=0-1,4-5"}, http.StatusOK, "0123456789"},
+		{
+			"strong If-Range",
+			[]string{"Range", "bytes=2-4", "If-Range", etag},
+			http.StatusPartialContent, "234",
+		},
+		{
+			"weak If-Range",
+			[]string{"Range", "bytes=2-4", "If-Range", "W/" + etag},
+			http.StatusOK, "0123456789",
+		},
+		{"If-None-Match", []string{"If-None-Match", etag}, http.StatusNotModified, ""},
+	}
+
+	for _, test := range tests {
+		res := s.do("GET", path, "", nil, test.headers...)
+
+		if res.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, res.Code, test.status)
+
+			continue
+		}
+
+		if test.body != "" && res.Body.String() != test.body {
+			t.Errorf("%s: got %q, want %q", test.name, res.Body.String(), test.body)
+		}
+	}
+
+	res := s.do("GET", path, "", nil, "Range", "bytes=10-")
+	if got := res.Header().Get("Content-Range"); got != "bytes */10" {
+		t.Errorf("Content-Range of an unsatisfiable range = %q, want %q", got, "bytes */10")
+	}
+
+	res = s.do("GET", path, "", nil, "Range", "bytes=-2")
+	if got := res.Header().Get("Content-Range"); got != "bytes 8-9/10" {
+		t.Errorf("Content-Range of a suffix range = %q, want %q", got, "bytes 8-9/10")
+	}
+}
+
+// createAPIToken mints a personal API token with a login session.
+func (s *testServer) createAPIToken(session string, scopes ...string) (string, uuid.UUID) {
+	s.t.Helper()
+
+	var created struct {
+		Data  models.APIToken `json:"data"`
+		Token string          `json:"token"`
+	}
+
+	s.doJSON("POST", "/api/v1/tokens", session, gin.H{"name": "ci", "scopes": scopes}).
+		expect(http.StatusCreated).decode(&created)
+
+	return created.Token, created.Data.ID
+}
+
+func TestAPITokens(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+	bob := s.signup("bob@example.com")
+
+	paste := s.createPaste(alice.Token, "private", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	reader, readerId := s.createAPIToken(alice.Token, auth.ScopePasteRead)
+	writer, _ := s.createAPIToken(alice.Token, auth.ScopePasteWrite)
+
+	s.do("GET", path+"/file", reader, nil).expect(http.StatusOK)
+
+	// Tokens are limited to their scopes.
+	s.do("GET", path+"/file", writer, nil).expect(http.StatusForbidden)
+	s.doFile("POST", "/api/v1/paste", reader, "new").expect(http.StatusForbidden)
+	s.share(reader, paste.ID, "bob@example.com", "viewer").expect(http.StatusForbidden)
+	s.share(writer, paste.ID, "bob@example.com", "viewer").expect(http.StatusForbidden)
+	s.createPaste(writer, "new", "Pastey-Visibility", "private")
+
+	// Tokens can't manage tokens, or mint themselves new scopes.
+	everything := gin.H{"name": "escalated", "scopes": auth.Scopes}
+
+	s.doJSON("POST", "/api/v1/tokens", reader, everything).expect(http.StatusForbidden)
+	s.do("GET", "/api/v1/tokens", reader, nil).expect(http.StatusForbidden)
+	s.do("DELETE", "/api/v1/tokens/"+readerId.String(), writer, nil).
+		expect(http.StatusForbidden)
+	s.do("POST", "/auth/v1/logout", reader, nil).expect(http.StatusForbidden)
+
+	// Revoked tokens stop working straight away.
+	s.do("DELETE", "/api/v1/tokens/"+readerId.String(), alice.Token, nil).
+		expect(http.StatusOK)
+	s.do("GET", path+"/file", reader, nil).expect(http.StatusUnauthorized)
+	s.do("DELETE", "/api/v1/tokens/"+readerId.String(), alice.Token, nil).
+		expect(http.StatusNotFound)
+
+	// So do expired ones. They can't be created already expired, so one is
+	// stored directly.
+	past := time.Now().Add(-time.Minute)
+
+	s.doJSON("POST", "/api/v1/tokens", alice.Token, gin.H{
+		"name": "expired", "scopes": []string{auth.ScopePasteRead}, "expires_at": past,
+	}).expect(http.StatusBadRequest)
+
+	expired, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := s.store.Users.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.store.Users.CreateAPITokenRecord(&models.APIToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Name:      "expired",
+		Prefix:    expired[:10],
+		TokenHash: auth.HashToken(expired),
+		Scopes:    auth.ScopePasteRead,
+		ExpiresAt: &past,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.do("GET", path+"/file", expired, nil).expect(http.StatusUnauthorized)
+	s.do("GET", path+"/file", "pst_unknown", nil).expect(http.StatusUnauthorized)
+
+	// Another user's token doesn't reach the private paste.
+	bobs, _ := s.createAPIToken(bob.Token, auth.ScopePasteRead)
+
+	s.do("GET", path+"/file", bobs, nil).expect(http.StatusUnauthorized)
+}
+
+// shortReads hands out stored content a few bytes at a time, as network
+// bodies do.
+type shortReads struct {
+	fileupload.Storage
+}
+
+func (s shortReads) Get(key string) (io.ReadCloser, int64, error) {
+	body, size, err := s.Storage.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{iotest.HalfReader(body), body}, size, nil
+}
+
+func TestLargePastesAreStreamed(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.Storage = shortReads{s.storage}
+	alice := s.signup("alice@example.com")
+
+	content := strings.Repeat("a log line that goes on for a while\n", 1<<16)
+
+	// The body is written as it is sent, so it has no length up front.
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		form.WriteField("language", "go")
+
+		part, _ := form.CreateFormFile("file", "paste.log")
+		io.Copy(part, strings.NewReader(content))
+
+		// Fields after the file are ignored.
+		form.WriteField("burn_after_reading", "true")
+
+		writer.CloseWithError(form.Close())
+	}()
+
+	var created struct {
+		Data models.Paste `json:"data"`
+	}
+
+	s.do(
+		"POST", "/api/v1/paste", alice.Token, body,
+		"Content-Type", form.FormDataContentType(),
+		"Pastey-Title", "log", "Pastey-Visibility", "private",
+	).expect(http.StatusCreated).decode(&created)
+
+	paste := created.Data
+	if paste.Size != int64(len(content)) || paste.Language != "go" || paste.BurnAfterReading {
+		t.Errorf(
+			"created %d bytes in %q, burning %v; want %d bytes in go, not burning",
+			paste.Size, paste.Language, paste.BurnAfterReading, len(content),
+		)
+	}
+
+	res := s.do("GET", "/api/v1/paste/"+paste.ID+"/file", alice.Token, nil).
+		expect(http.StatusOK)
+
+	if got := res.Header().Get("Content-Length"); got != strconv.Itoa(len(content)) {
+		t.Errorf("Content-Length = %s, want %d", got, len(content))
+	}
+
+	if res.Body.String() != content {
+		t.Errorf("downloaded %d bytes that don't match the upload", res.Body.Len())
+	}
+}
+
+func TestUploadsNeedAFilePart(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup("alice@example.com")
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+	form.WriteField("language", "go")
+	form.Close()
+
+	s.do(
+		"POST", "/api/v1/paste", alice.Token, &body,
+		"Content-Type", form.FormDataContentType(),
+		"Pastey-Title", "empty", "Pastey-Visibility", "private",
+	).expect(http.StatusBadRequest)
+
+	s.do(
+		"POST", "/api/v1/paste", alice.Token, strings.NewReader("not a form"),
+		"Content-Type", "text/plain",
+		"Pastey-Title", "empty", "Pastey-Visibility", "private",
+	).expect(http.StatusBadRequest)
+
+	s.storage.Walk(func(key string) error {
+		t.Errorf("blob %q was stored without a file part", key)
+
+		return nil
+	})
+}
+
+var errTestStorage = errors.New("storage is down")
+
+// failingStorage fails uploads part way through and, while failDeletes is
+// set, deletions.
+type failingStorage struct {
+	fileupload.Storage
+	failPuts    bool
+	failDeletes bool
+}
+
+func (s *failingStorage) Put(key string, body io.Reader) error {
+	if !s.failPuts {
+		return s.Storage.Put(key, body)
+	}
+
+	return s.Storage.Put(key, io.MultiReader(
+		io.LimitReader(body, 3), iotest.ErrReader(errTestStorage),
+	))
+}
+
+func (s *failingStorage) Delete(key string) error {
+	if s.failDeletes {
+		return errTestStorage
+	}
+
+	return s.Storage.Delete(key)
+}
+
+// failingCreates fails to record new pastes, after their upload.
+type failingCreates struct {
+	database.PasteStore
+}
+
+func (failingCreates) CreatePaste(
+	*models.Paste, *models.PasteRevision, *models.PasteAccess,
+) error {
+	return errTestStorage
+}
+
+// expectNoPastes checks that a failed create left nothing behind: no paste,
+// no blob and no pending deletion.
+func (s *testServer) expectNoPastes(email string) {
+	s.t.Helper()
+
+	user, err := s.store.Users.GetUserByEmail(email)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	pastes, err := s.store.Pastes.ListPastes(database.PasteListOptions{
+		UserID: user.ID, Sort: database.SortCreatedAt, Limit: 10,
+	})
+	if err != nil || len(pastes) != 0 {
+		s.t.Errorf("pastes after a failed create are %v, %v", pastes, err)
+	}
+
+	s.storage.Walk(func(key string) error {
+		s.t.Errorf("blob %q was left behind", key)
+
+		return nil
+	})
+
+	deletions, err := s.store.Pastes.GetBlobDeletions()
+	if err != nil || len(deletions) != 0 {
+		s.t.Errorf("deletions after a failed create are %v, %v", deletions, err)
+	}
+}
+
+func TestFailedUploadCreatesNothing(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.Storage = &failingStorage{Storage: s.storage, failPuts: true}
+	alice := s.signup("alice@example.com")
+
+	s.doFile(
+		"POST", "/api/v1/paste", alice.Token, "never stored",
+		"Pastey-Title", "test", "Pastey-Visibility", "private",
+	).expect(http.StatusInternalServerError)
+
+	s.expectNoPastes("alice@example.com")
+}
+
+func TestFailedCreateDeletesItsUpload(t *testing.T) {
+	memory := database.NewMemory()
+	s := newTestServerWithStore(t, &database.Store{
+		Users: memory, Pastes: failingCreates{memory}, Access: memory,
+	})
+	alice := s.signup("alice@example.com")
+
+	s.doFile(
+		"POST", "/api/v1/paste", alice.Token, "uploaded, then dropped",
+		"Pastey-Title", "test", "Pastey-Visibility", "private",
+	).expect(http.StatusInternalServerError)
+
+	s.expectNoPastes("alice@example.com")
+}
+
+func TestDeletedPasteBlobsAreRetried(t *testing.T) {
+	s := newTestServer(t)
+	storage := &failingStorage{Storage: s.storage}
+	s.handler.Storage = storage
+	alice := s.signup("alice@example.com")
+
+	paste := s.createPaste(alice.Token, "one", "Pastey-Visibility", "private")
+	path := "/api/v1/paste/" + paste.ID
+
+	s.doFile("PUT", path, alice.Token, "two").expect(http.StatusOK)
+
+	// The paste is gone even though its blobs can't be deleted yet.
+	storage.failDeletes = true
+
+	s.do("DELETE", path, alice.Token, nil).expect(http.StatusOK)
+	s.do("GET", path, alice.Token, nil).expect(http.StatusNotFound)
+
+	deletions, err := s.store.Pastes.GetBlobDeletions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for _, deletion := range deletions {
+		keys = append(keys, deletion.Key)
+
+		if deletion.Attempts != 1 || deletion.LastError == "" {
+			t.Errorf("deletion of %q is %+v, want one failed attempt", deletion.Key, deletion)
+		}
+	}
+
+	slices.Sort(keys)
+
+	if stored := walkStorage(t, s.storage); len(keys) != 2 || !slices.Equal(keys, stored) {
+		t.Fatalf("scheduled deletions are %v, want both stored revisions %v", keys, stored)
+	}
+
+	// Once storage is back, the retry deletes them.
+	storage.failDeletes = false
+
+	err = reaper.DeleteBlobs(s.store.Pastes, storage, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := walkStorage(t, s.storage); len(stored) != 0 {
+		t.Errorf("blobs %v are left after the retry", stored)
+	}
+
+	deletions, err = s.store.Pastes.GetBlobDeletions()
+	if err != nil || len(deletions) != 0 {
+		t.Errorf("deletions after the retry are %v, %v", deletions, err)
+	}
+}
+
+func walkStorage(t *testing.T, storage fileupload.Storage) []string {
+	t.Helper()
+
+	keys := []string{}
+
+	err := storage.Walk(func(key string) error {
+		keys = append(keys, key)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}